@@ -0,0 +1,151 @@
+// Package admin provides an HTTP API for inspecting and operating a running
+// Bayeux server: listing sessions and channels, disconnecting sessions and
+// publishing messages.
+//
+// The handler serves the following routes, relative to where it is mounted
+// (use http.StripPrefix to mount it under a path):
+//
+//	GET    /sessions            list sessions
+//	GET    /sessions/{id}       show one session
+//	DELETE /sessions/{id}       disconnect a session
+//	GET    /channels            list channels with subscriber counts
+//	GET    /channels/{name...}  list the subscribers of a channel
+//	POST   /channels/{name...}  publish the request body as message data
+//	GET    /ratelimits          count publishes rejected by rate limits
+//
+// All responses are JSON. Every request must pass the handler's Authorizer.
+// A publish rejected by one of the channel's publish interceptors receives
+// 422 Unprocessable Entity.
+package admin
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/charlinchui/galliard/server"
+)
+
+// maxPublishBytes bounds the body of a publish request.
+const maxPublishBytes = 1 << 20
+
+// Authorizer decides whether a request may use the admin API.
+type Authorizer func(r *http.Request) bool
+
+// BearerToken returns an Authorizer accepting requests that carry
+// "Authorization: Bearer <token>". An empty token rejects every request.
+func BearerToken(token string) Authorizer {
+	return func(r *http.Request) bool {
+		got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		return ok && token != "" && subtle.ConstantTimeCompare([]byte(got), []byte(token)) == 1
+	}
+}
+
+// Handler serves the admin API for a Server.
+type Handler struct {
+	server *server.Server
+	auth   Authorizer
+	mux    *http.ServeMux
+}
+
+// NewHandler returns an admin Handler for srv protected by auth.
+// If auth is nil every request is rejected.
+func NewHandler(srv *server.Server, auth Authorizer) *Handler {
+	h := &Handler{server: srv, auth: auth, mux: http.NewServeMux()}
+	h.mux.HandleFunc("GET /sessions", h.listSessions)
+	h.mux.HandleFunc("GET /sessions/{id}", h.getSession)
+	h.mux.HandleFunc("DELETE /sessions/{id}", h.disconnectSession)
+	h.mux.HandleFunc("GET /channels", h.listChannels)
+	h.mux.HandleFunc("GET /channels/{name...}", h.getChannel)
+	h.mux.HandleFunc("POST /channels/{name...}", h.publish)
+	h.mux.HandleFunc("GET /ratelimits", h.rateLimits)
+	return h
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h.auth == nil || !h.auth(r) {
+		writeError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	h.mux.ServeHTTP(w, r)
+}
+
+func (h *Handler) listSessions(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, h.server.SessionList())
+}
+
+func (h *Handler) getSession(w http.ResponseWriter, r *http.Request) {
+	info, ok := h.server.Session(r.PathValue("id"))
+	if !ok {
+		writeError(w, http.StatusNotFound, "unknown session")
+		return
+	}
+	writeJSON(w, http.StatusOK, info)
+}
+
+func (h *Handler) disconnectSession(w http.ResponseWriter, r *http.Request) {
+	if !h.server.Disconnect(r.PathValue("id")) {
+		writeError(w, http.StatusNotFound, "unknown session")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) listChannels(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, h.server.ChannelList())
+}
+
+func (h *Handler) getChannel(w http.ResponseWriter, r *http.Request) {
+	name := "/" + r.PathValue("name")
+	subscribers, ok := h.server.ChannelSubscribers(name)
+	if !ok {
+		writeError(w, http.StatusNotFound, "unknown channel")
+		return
+	}
+	writeJSON(w, http.StatusOK, struct {
+		Name        string   `json:"name"`
+		Subscribers []string `json:"subscribers"`
+	}{name, subscribers})
+}
+
+func (h *Handler) publish(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxPublishBytes))
+	if err != nil {
+		writeError(w, http.StatusRequestEntityTooLarge, "body too large")
+		return
+	}
+	if !json.Valid(body) {
+		writeError(w, http.StatusBadRequest, "body must be a JSON value")
+		return
+	}
+	if err := h.server.Publish("/"+r.PathValue("name"), body); err != nil {
+		if errors.Is(err, server.ErrInvalidChannel) {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if errors.Is(err, server.ErrRejected) {
+			writeError(w, http.StatusUnprocessableEntity, err.Error())
+			return
+		}
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (h *Handler) rateLimits(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, h.server.RateLimitStats())
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"error": msg})
+}
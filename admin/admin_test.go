@@ -0,0 +1,159 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/charlinchui/galliard/message"
+	"github.com/charlinchui/galliard/server"
+)
+
+func newTestHandler(t *testing.T) (*Handler, *server.Server, string) {
+	t.Helper()
+	srv := server.NewServer()
+	t.Cleanup(srv.Close)
+	clientID := srv.HandleMessage(&message.BayeuxMessage{Channel: "/meta/handshake"}).ClientID
+	srv.HandleMessage(&message.BayeuxMessage{Channel: "/meta/subscribe", ClientID: clientID, Subscription: "/chat/room1"})
+	return NewHandler(srv, BearerToken("secret")), srv, clientID
+}
+
+func do(h http.Handler, method, path, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer secret")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestAdmin_Unauthorized(t *testing.T) {
+	h, _, _ := newTestHandler(t)
+	req := httptest.NewRequest(http.MethodGet, "/sessions", nil)
+	req.Header.Set("Authorization", "Bearer wrong")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401, got %d", rec.Code)
+	}
+
+	srv := server.NewServer()
+	t.Cleanup(srv.Close)
+	rec = do(NewHandler(srv, nil), http.MethodGet, "/sessions", "")
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("expected nil authorizer to reject, got %d", rec.Code)
+	}
+}
+
+func TestAdmin_Sessions(t *testing.T) {
+	h, _, clientID := newTestHandler(t)
+	rec := do(h, http.MethodGet, "/sessions", "")
+	var sessions []server.SessionInfo
+	if err := json.Unmarshal(rec.Body.Bytes(), &sessions); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if len(sessions) != 1 || sessions[0].ID != clientID {
+		t.Fatalf("unexpected sessions: %+v", sessions)
+	}
+
+	rec = do(h, http.MethodGet, "/sessions/"+clientID, "")
+	if rec.Code != http.StatusOK {
+		t.Errorf("expected 200, got %d", rec.Code)
+	}
+	rec = do(h, http.MethodGet, "/sessions/nope", "")
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("expected 404, got %d", rec.Code)
+	}
+
+	rec = do(h, http.MethodDelete, "/sessions/"+clientID, "")
+	if rec.Code != http.StatusNoContent {
+		t.Errorf("expected 204, got %d", rec.Code)
+	}
+	rec = do(h, http.MethodDelete, "/sessions/"+clientID, "")
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("expected 404 after disconnect, got %d", rec.Code)
+	}
+}
+
+func TestAdmin_Channels(t *testing.T) {
+	h, _, clientID := newTestHandler(t)
+	rec := do(h, http.MethodGet, "/channels", "")
+	var channels []server.ChannelInfo
+	if err := json.Unmarshal(rec.Body.Bytes(), &channels); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if len(channels) != 1 || channels[0].Name != "/chat/room1" || channels[0].Subscribers != 1 {
+		t.Fatalf("unexpected channels: %+v", channels)
+	}
+
+	rec = do(h, http.MethodGet, "/channels/chat/room1", "")
+	var ch struct {
+		Name        string   `json:"name"`
+		Subscribers []string `json:"subscribers"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &ch); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if ch.Name != "/chat/room1" || len(ch.Subscribers) != 1 || ch.Subscribers[0] != clientID {
+		t.Errorf("unexpected channel: %+v", ch)
+	}
+	if rec := do(h, http.MethodGet, "/channels/missing", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("expected 404, got %d", rec.Code)
+	}
+}
+
+func TestAdmin_Publish(t *testing.T) {
+	h, srv, clientID := newTestHandler(t)
+	rec := do(h, http.MethodPost, "/channels/chat/room1", `{"text":"maintenance at noon"}`)
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("expected 202, got %d", rec.Code)
+	}
+	for srv.FanoutQueueDepth() > 0 {
+		time.Sleep(time.Millisecond)
+	}
+	resp := srv.HandleMessage(&message.BayeuxMessage{Channel: "/meta/connect", ClientID: clientID})
+	if resp.Channel != "/chat/room1" || string(resp.Data) != `{"text":"maintenance at noon"}` {
+		t.Errorf("expected published message to be delivered, got %+v", resp)
+	}
+
+	if rec := do(h, http.MethodPost, "/channels/chat/room1", `not json`); rec.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for invalid body, got %d", rec.Code)
+	}
+	if rec := do(h, http.MethodPost, "/channels/meta/connect", `{}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for meta channel, got %d", rec.Code)
+	}
+}
+
+func TestAdmin_PublishRejected(t *testing.T) {
+	h, srv, _ := newTestHandler(t)
+	ch, err := srv.Channel("/chat/room1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ch.AddPublishInterceptor(func(_ context.Context, _ *server.Channel, msg *message.BayeuxMessage) error {
+		if strings.Contains(string(msg.Data), "spam") {
+			return errors.New("spam not allowed")
+		}
+		return nil
+	})
+	rec := do(h, http.MethodPost, "/channels/chat/room1", `"spam"`)
+	if rec.Code != http.StatusUnprocessableEntity || !strings.Contains(rec.Body.String(), "spam not allowed") {
+		t.Errorf("expected 422 for a rejected publish, got %d %s", rec.Code, rec.Body)
+	}
+}
+
+func TestAdmin_RateLimits(t *testing.T) {
+	h, _, _ := newTestHandler(t)
+	rec := do(h, http.MethodGet, "/ratelimits", "")
+	var stats server.RateLimitStats
+	if err := json.Unmarshal(rec.Body.Bytes(), &stats); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if rec.Code != http.StatusOK || stats != (server.RateLimitStats{}) {
+		t.Errorf("unexpected rate limit stats: %d %+v", rec.Code, stats)
+	}
+}
@@ -0,0 +1,207 @@
+// Package auth provides handshake authenticators for the Bayeux server.
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/charlinchui/galliard/message"
+	"github.com/charlinchui/galliard/server"
+)
+
+// Errors returned by JWTVerifier.
+var (
+	ErrMissingToken     = errors.New("missing token")
+	ErrMalformedToken   = errors.New("malformed token")
+	ErrUnsupportedAlg   = errors.New("unsupported signing algorithm")
+	ErrInvalidSignature = errors.New("invalid token signature")
+	ErrTokenExpired     = errors.New("token expired")
+	ErrTokenNotYetValid = errors.New("token not yet valid")
+	ErrInvalidAudience  = errors.New("invalid token audience")
+	ErrInvalidIssuer    = errors.New("invalid token issuer")
+)
+
+// TokenExt is the handshake ext field JWTVerifier reads the token from.
+const TokenExt = "token"
+
+// JWTVerifier is a server.Authenticator for JSON Web Tokens signed with
+// HMAC (HS256, HS384, HS512) or RSA (RS256, RS384, RS512).
+//
+// The token is read from the handshake's ext field TokenExt, or failing
+// that from an "Authorization: Bearer" header on the transport request.
+// Tokens must carry an "exp" claim; "nbf", "aud" and "iss" are checked when
+// present or configured.
+type JWTVerifier struct {
+	// HMACKey verifies HS* tokens. Leave nil to reject them.
+	HMACKey []byte
+
+	// RSAKey verifies RS* tokens. Leave nil to reject them.
+	RSAKey *rsa.PublicKey
+
+	// Audience, if set, must appear in the token's "aud" claim.
+	Audience string
+
+	// Issuer, if set, must equal the token's "iss" claim.
+	Issuer string
+
+	// Leeway tolerates clock skew when checking "exp" and "nbf".
+	Leeway time.Duration
+
+	// Now returns the current time. It defaults to time.Now.
+	Now func() time.Time
+}
+
+// Authenticate implements server.Authenticator.
+func (v *JWTVerifier) Authenticate(ctx context.Context, msg *message.BayeuxMessage) (*server.Principal, error) {
+	token, err := tokenFrom(ctx, msg)
+	if err != nil {
+		return nil, err
+	}
+	claims, err := v.Verify(token)
+	if err != nil {
+		return nil, err
+	}
+	sub, _ := claims["sub"].(string)
+	return &server.Principal{Subject: sub, Claims: claims}, nil
+}
+
+func tokenFrom(ctx context.Context, msg *message.BayeuxMessage) (string, error) {
+	var token string
+	if ok, err := msg.DecodeExt(TokenExt, &token); err != nil {
+		return "", ErrMalformedToken
+	} else if ok && token != "" {
+		return token, nil
+	}
+	if req := server.RequestFromContext(ctx); req != nil && req.Header != nil {
+		if bearer, ok := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer "); ok && bearer != "" {
+			return bearer, nil
+		}
+	}
+	return "", ErrMissingToken
+}
+
+// Verify checks the token's signature and registered claims and returns
+// its claims.
+func (v *JWTVerifier) Verify(token string) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrMalformedToken
+	}
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, err
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrMalformedToken
+	}
+	if err := v.verifySignature(header.Alg, parts[0]+"."+parts[1], sig); err != nil {
+		return nil, err
+	}
+
+	var claims map[string]interface{}
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, err
+	}
+	if err := v.checkClaims(claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+func decodeSegment(seg string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return ErrMalformedToken
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return ErrMalformedToken
+	}
+	return nil
+}
+
+// algorithms maps supported JWT "alg" values to their hash functions.
+var algorithms = map[string]crypto.Hash{
+	"HS256": crypto.SHA256,
+	"HS384": crypto.SHA384,
+	"HS512": crypto.SHA512,
+	"RS256": crypto.SHA256,
+	"RS384": crypto.SHA384,
+	"RS512": crypto.SHA512,
+}
+
+func (v *JWTVerifier) verifySignature(alg, signed string, sig []byte) error {
+	hash, ok := algorithms[alg]
+	switch {
+	case ok && strings.HasPrefix(alg, "HS") && v.HMACKey != nil:
+		mac := hmac.New(hash.New, v.HMACKey)
+		mac.Write([]byte(signed))
+		if !hmac.Equal(mac.Sum(nil), sig) {
+			return ErrInvalidSignature
+		}
+		return nil
+	case ok && strings.HasPrefix(alg, "RS") && v.RSAKey != nil:
+		h := hash.New()
+		h.Write([]byte(signed))
+		if err := rsa.VerifyPKCS1v15(v.RSAKey, hash, h.Sum(nil), sig); err != nil {
+			return ErrInvalidSignature
+		}
+		return nil
+	default:
+		return fmt.Errorf("%w %q", ErrUnsupportedAlg, alg)
+	}
+}
+
+func (v *JWTVerifier) checkClaims(claims map[string]interface{}) error {
+	now := time.Now()
+	if v.Now != nil {
+		now = v.Now()
+	}
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return fmt.Errorf("%w: missing exp claim", ErrTokenExpired)
+	}
+	if now.After(time.Unix(int64(exp), 0).Add(v.Leeway)) {
+		return ErrTokenExpired
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(v.Leeway).Before(time.Unix(int64(nbf), 0)) {
+		return ErrTokenNotYetValid
+	}
+	if v.Issuer != "" {
+		if iss, _ := claims["iss"].(string); iss != v.Issuer {
+			return ErrInvalidIssuer
+		}
+	}
+	if v.Audience != "" && !hasAudience(claims["aud"], v.Audience) {
+		return ErrInvalidAudience
+	}
+	return nil
+}
+
+// hasAudience reports whether the "aud" claim, a string or an array of
+// strings, contains want.
+func hasAudience(aud interface{}, want string) bool {
+	switch aud := aud.(type) {
+	case string:
+		return aud == want
+	case []interface{}:
+		for _, a := range aud {
+			if s, ok := a.(string); ok && s == want {
+				return true
+			}
+		}
+	}
+	return false
+}
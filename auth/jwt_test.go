@@ -0,0 +1,175 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/charlinchui/galliard/message"
+	"github.com/charlinchui/galliard/server"
+)
+
+var testNow = time.Unix(1_700_000_000, 0)
+
+func encodeSegment(t *testing.T, v interface{}) string {
+	t.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func signHS256(t *testing.T, key []byte, claims map[string]interface{}) string {
+	t.Helper()
+	signed := encodeSegment(t, map[string]string{"alg": "HS256", "typ": "JWT"}) + "." + encodeSegment(t, claims)
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(signed))
+	return signed + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func signRS256(t *testing.T, key *rsa.PrivateKey, claims map[string]interface{}) string {
+	t.Helper()
+	signed := encodeSegment(t, map[string]string{"alg": "RS256", "typ": "JWT"}) + "." + encodeSegment(t, claims)
+	sum := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, sum[:])
+	if err != nil {
+		t.Fatalf("sign: %v", err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func validClaims() map[string]interface{} {
+	return map[string]interface{}{
+		"sub": "alice",
+		"iss": "https://id.example.com",
+		"aud": []string{"galliard", "other"},
+		"exp": testNow.Add(time.Hour).Unix(),
+	}
+}
+
+func newVerifier() *JWTVerifier {
+	return &JWTVerifier{
+		HMACKey:  []byte("secret"),
+		Audience: "galliard",
+		Issuer:   "https://id.example.com",
+		Now:      func() time.Time { return testNow },
+	}
+}
+
+func TestVerifyHS256(t *testing.T) {
+	v := newVerifier()
+	claims, err := v.Verify(signHS256(t, []byte("secret"), validClaims()))
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if claims["sub"] != "alice" {
+		t.Errorf("expected sub alice, got %v", claims["sub"])
+	}
+}
+
+func TestVerifyRS256(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	v := newVerifier()
+	v.RSAKey = &key.PublicKey
+	if _, err := v.Verify(signRS256(t, key, validClaims())); err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+
+	other, _ := rsa.GenerateKey(rand.Reader, 2048)
+	if _, err := v.Verify(signRS256(t, other, validClaims())); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("expected ErrInvalidSignature, got %v", err)
+	}
+}
+
+func TestVerifyRejections(t *testing.T) {
+	v := newVerifier()
+	with := func(key string, value interface{}) map[string]interface{} {
+		c := validClaims()
+		if value == nil {
+			delete(c, key)
+		} else {
+			c[key] = value
+		}
+		return c
+	}
+	none := encodeSegment(t, map[string]string{"alg": "none"}) + "." + encodeSegment(t, validClaims()) + "."
+
+	cases := map[string]struct {
+		token string
+		want  error
+	}{
+		"wrong key":    {signHS256(t, []byte("other"), validClaims()), ErrInvalidSignature},
+		"expired":      {signHS256(t, []byte("secret"), with("exp", testNow.Add(-time.Minute).Unix())), ErrTokenExpired},
+		"no exp":       {signHS256(t, []byte("secret"), with("exp", nil)), ErrTokenExpired},
+		"not yet":      {signHS256(t, []byte("secret"), with("nbf", testNow.Add(time.Minute).Unix())), ErrTokenNotYetValid},
+		"audience":     {signHS256(t, []byte("secret"), with("aud", "someone-else")), ErrInvalidAudience},
+		"issuer":       {signHS256(t, []byte("secret"), with("iss", "https://evil.example.com")), ErrInvalidIssuer},
+		"alg none":     {none, ErrUnsupportedAlg},
+		"malformed":    {"not-a-token", ErrMalformedToken},
+		"bad encoding": {"a.b.c", ErrMalformedToken},
+	}
+	for name, c := range cases {
+		if _, err := v.Verify(c.token); !errors.Is(err, c.want) {
+			t.Errorf("%s: expected %v, got %v", name, c.want, err)
+		}
+	}
+}
+
+func TestVerifyLeeway(t *testing.T) {
+	v := newVerifier()
+	v.Leeway = time.Minute
+	c := validClaims()
+	c["exp"] = testNow.Add(-30 * time.Second).Unix()
+	if _, err := v.Verify(signHS256(t, []byte("secret"), c)); err != nil {
+		t.Errorf("expected leeway to accept recently expired token, got %v", err)
+	}
+}
+
+func TestJWTHandshake(t *testing.T) {
+	srv, err := server.New(server.WithAuthenticator(newVerifier()))
+	if err != nil {
+		t.Fatalf("server.New: %v", err)
+	}
+	defer srv.Close()
+	token := signHS256(t, []byte("secret"), validClaims())
+
+	resp := srv.HandleMessage(&message.BayeuxMessage{
+		Channel: "/meta/handshake",
+		Ext:     map[string]json.RawMessage{TokenExt: message.MustData(token)},
+	})
+	if resp.Successful == nil || !*resp.Successful {
+		t.Fatalf("expected handshake with ext token to succeed, got %+v", resp)
+	}
+	if p := srv.Principal(resp.ClientID); p == nil || p.Subject != "alice" {
+		t.Errorf("expected principal alice, got %+v", p)
+	}
+
+	ctx := server.WithRequest(context.Background(), &server.Request{
+		Header: http.Header{"Authorization": {"Bearer " + token}},
+	})
+	resp = srv.HandleMessageContext(ctx, &message.BayeuxMessage{Channel: "/meta/handshake"})
+	if resp.Successful == nil || !*resp.Successful {
+		t.Errorf("expected handshake with bearer header to succeed, got %+v", resp)
+	}
+
+	resp = srv.HandleMessage(&message.BayeuxMessage{Channel: "/meta/handshake", ID: "h1"})
+	if resp.Successful == nil || *resp.Successful || resp.Error != "401::missing token" {
+		t.Errorf("expected 401 for missing token, got %+v", resp)
+	}
+	if resp.ClientID != "" || resp.ID != "h1" {
+		t.Errorf("expected no session for rejected handshake, got %+v", resp)
+	}
+}
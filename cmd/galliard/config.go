@@ -0,0 +1,284 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/charlinchui/galliard/message"
+	"github.com/charlinchui/galliard/server"
+	"github.com/charlinchui/galliard/transport"
+)
+
+// Config is the on-disk configuration of the galliard server.
+// It can be written as JSON or YAML; field names are the same in both.
+type Config struct {
+	// Listen is the TCP address to listen on, e.g. ":8080".
+	Listen string `json:"listen" yaml:"listen"`
+
+	Paths       PathsConfig       `json:"paths" yaml:"paths"`
+	Transports  []string          `json:"transports" yaml:"transports"`
+	Timeouts    TimeoutsConfig    `json:"timeouts" yaml:"timeouts"`
+	TLS         TLSConfig         `json:"tls" yaml:"tls"`
+	Limits      LimitsConfig      `json:"limits" yaml:"limits"`
+	Compression CompressionConfig `json:"compression" yaml:"compression"`
+	Security    SecurityConfig    `json:"security" yaml:"security"`
+	Advice      AdviceConfig      `json:"advice" yaml:"advice"`
+	Sessions    SessionsConfig    `json:"sessions" yaml:"sessions"`
+}
+
+// PathsConfig sets the URL paths the handlers are mounted on.
+// An empty Admin path disables the admin API.
+type PathsConfig struct {
+	Bayeux string `json:"bayeux" yaml:"bayeux"`
+	Admin  string `json:"admin" yaml:"admin"`
+}
+
+// TimeoutsConfig sets the HTTP server timeouts. Zero values keep the
+// defaults of transport.NewHTTPServer.
+type TimeoutsConfig struct {
+	ReadHeader Duration `json:"readHeader" yaml:"readHeader"`
+	Read       Duration `json:"read" yaml:"read"`
+	Write      Duration `json:"write" yaml:"write"`
+	Idle       Duration `json:"idle" yaml:"idle"`
+	Shutdown   Duration `json:"shutdown" yaml:"shutdown"`
+}
+
+// TLSConfig enables HTTPS when both files are set.
+type TLSConfig struct {
+	CertFile string `json:"certFile" yaml:"certFile"`
+	KeyFile  string `json:"keyFile" yaml:"keyFile"`
+}
+
+// LimitsConfig mirrors transport.Limits. Zero values keep the defaults.
+type LimitsConfig struct {
+	MaxBodyBytes    int64 `json:"maxBodyBytes" yaml:"maxBodyBytes"`
+	MaxMessages     int   `json:"maxMessages" yaml:"maxMessages"`
+	MaxMessageBytes int   `json:"maxMessageBytes" yaml:"maxMessageBytes"`
+	MaxDepth        int   `json:"maxDepth" yaml:"maxDepth"`
+}
+
+// CompressionConfig controls response compression.
+type CompressionConfig struct {
+	Disabled bool `json:"disabled" yaml:"disabled"`
+	MinSize  int  `json:"minSize" yaml:"minSize"`
+}
+
+// SecurityConfig holds cross-origin rules and admin API credentials.
+type SecurityConfig struct {
+	AllowedOrigins   []string `json:"allowedOrigins" yaml:"allowedOrigins"`
+	AllowCredentials bool     `json:"allowCredentials" yaml:"allowCredentials"`
+	AllowedHeaders   []string `json:"allowedHeaders" yaml:"allowedHeaders"`
+	CORSMaxAge       Duration `json:"corsMaxAge" yaml:"corsMaxAge"`
+
+	// AdminToken is the bearer token required by the admin API.
+	AdminToken string `json:"adminToken" yaml:"adminToken"`
+
+	// Rules restrict subscribing and publishing by channel pattern.
+	// The first matching rule applies; unmatched channels are open.
+	Rules []RuleConfig `json:"rules" yaml:"rules"`
+}
+
+// RuleConfig is a server.ChannelRule.
+type RuleConfig struct {
+	Channel   string `json:"channel" yaml:"channel"`
+	Subscribe bool   `json:"subscribe" yaml:"subscribe"`
+	Publish   bool   `json:"publish" yaml:"publish"`
+}
+
+// AdviceConfig sets the advice sent to clients. Zero values keep the
+// server defaults.
+type AdviceConfig struct {
+	Reconnect string   `json:"reconnect" yaml:"reconnect"`
+	Interval  Duration `json:"interval" yaml:"interval"`
+	Timeout   Duration `json:"timeout" yaml:"timeout"`
+}
+
+// SessionsConfig controls session expiry and queueing.
+type SessionsConfig struct {
+	// Timeout expires sessions not heard from for this long.
+	Timeout Duration `json:"timeout" yaml:"timeout"`
+	// MaxQueue bounds the undelivered messages kept per session.
+	MaxQueue int `json:"maxQueue" yaml:"maxQueue"`
+	// Piggyback delivers queued messages with the replies to any request,
+	// not only to /meta/connect.
+	Piggyback bool `json:"piggyback" yaml:"piggyback"`
+}
+
+// Duration is a time.Duration written as a string such as "30s" or "2m".
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("duration must be a string like \"30s\": %w", err)
+	}
+	return d.parse(s)
+}
+
+func (d *Duration) UnmarshalYAML(value *yaml.Node) error {
+	return d.parse(value.Value)
+}
+
+func (d *Duration) parse(s string) error {
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+func defaultConfig() Config {
+	return Config{
+		Listen:     ":8080",
+		Paths:      PathsConfig{Bayeux: "/bayeux"},
+		Transports: []string{"long-polling"},
+		Timeouts:   TimeoutsConfig{Shutdown: Duration(15 * time.Second)},
+	}
+}
+
+// loadConfig reads the configuration file at path, choosing the format from
+// its extension, and applies defaults for unset fields.
+func loadConfig(path string) (Config, error) {
+	cfg := defaultConfig()
+	if path == "" {
+		return cfg, cfg.validate()
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return cfg, err
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		err = dec.Decode(&cfg)
+	case ".yaml", ".yml":
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		err = dec.Decode(&cfg)
+	default:
+		return cfg, fmt.Errorf("config %s: unsupported format, use .json, .yaml or .yml", path)
+	}
+	if err != nil {
+		return cfg, fmt.Errorf("config %s: %w", path, err)
+	}
+	return cfg, cfg.validate()
+}
+
+func (c Config) validate() error {
+	if c.Listen == "" {
+		return errors.New("config: listen address is required")
+	}
+	if !strings.HasPrefix(c.Paths.Bayeux, "/") {
+		return errors.New("config: paths.bayeux must start with /")
+	}
+	if c.Paths.Admin != "" {
+		if !strings.HasPrefix(c.Paths.Admin, "/") {
+			return errors.New("config: paths.admin must start with /")
+		}
+		if c.Paths.Admin == c.Paths.Bayeux {
+			return errors.New("config: paths.admin and paths.bayeux must differ")
+		}
+		if c.Security.AdminToken == "" {
+			return errors.New("config: security.adminToken is required when the admin API is enabled")
+		}
+	}
+	for _, t := range c.Transports {
+		if t != "long-polling" {
+			return fmt.Errorf("config: unsupported transport %q", t)
+		}
+	}
+	if len(c.Transports) == 0 {
+		return errors.New("config: at least one transport is required")
+	}
+	if (c.TLS.CertFile == "") != (c.TLS.KeyFile == "") {
+		return errors.New("config: tls.certFile and tls.keyFile must be set together")
+	}
+	for _, r := range c.Security.Rules {
+		if !strings.HasPrefix(r.Channel, "/") {
+			return fmt.Errorf("config: security rule channel %q must start with /", r.Channel)
+		}
+	}
+	// A held connect lasts up to the advice timeout plus interval; the
+	// write timeout must not cut it off.
+	write := transport.NewHTTPServer("", nil).WriteTimeout
+	if c.Timeouts.Write > 0 {
+		write = time.Duration(c.Timeouts.Write)
+	}
+	advice := c.advice()
+	if held := time.Duration(advice.Timeout+advice.Interval) * time.Millisecond; write <= held {
+		return fmt.Errorf("config: timeouts.write %v must exceed advice timeout plus interval (%v)", write, held)
+	}
+	return nil
+}
+
+// advice returns the advice settings with server defaults filled in.
+func (c Config) advice() message.Advice {
+	advice := message.Advice{Reconnect: "retry", Timeout: 10000}
+	if c.Advice.Reconnect != "" {
+		advice.Reconnect = c.Advice.Reconnect
+	}
+	if c.Advice.Timeout > 0 {
+		advice.Timeout = int(time.Duration(c.Advice.Timeout) / time.Millisecond)
+	}
+	advice.Interval = int(time.Duration(c.Advice.Interval) / time.Millisecond)
+	return advice
+}
+
+// serverOptions translates the advice, session and security settings into
+// server options. The server validates them when newServer creates it,
+// before the listener is bound.
+func (c Config) serverOptions() []server.Option {
+	opts := []server.Option{
+		server.WithAdvice(c.advice()),
+		server.WithSessionTimeout(time.Duration(c.Sessions.Timeout)),
+		server.WithMaxQueue(c.Sessions.MaxQueue),
+		server.WithPiggyback(c.Sessions.Piggyback),
+	}
+	if len(c.Security.Rules) > 0 {
+		rules := make(server.RulePolicy, len(c.Security.Rules))
+		for i, r := range c.Security.Rules {
+			rules[i] = server.ChannelRule{Pattern: r.Channel, Subscribe: r.Subscribe, Publish: r.Publish}
+		}
+		opts = append(opts, server.WithPolicy(rules))
+	}
+	return opts
+}
+
+// configureHandler applies the limits, compression and CORS settings to h.
+func (c Config) configureHandler(h *transport.HTTPHandler) {
+	if c.Limits.MaxBodyBytes > 0 {
+		h.Limits.MaxBodyBytes = c.Limits.MaxBodyBytes
+	}
+	if c.Limits.MaxMessages > 0 {
+		h.Limits.MaxMessages = c.Limits.MaxMessages
+	}
+	if c.Limits.MaxMessageBytes > 0 {
+		h.Limits.MaxMessageBytes = c.Limits.MaxMessageBytes
+	}
+	if c.Limits.MaxDepth > 0 {
+		h.Limits.MaxDepth = c.Limits.MaxDepth
+	}
+	if c.Compression.Disabled {
+		h.Compression = nil
+	} else if c.Compression.MinSize > 0 {
+		h.Compression.MinSize = c.Compression.MinSize
+	}
+	if len(c.Security.AllowedOrigins) > 0 {
+		h.CORS = &transport.CORS{
+			AllowedOrigins:   c.Security.AllowedOrigins,
+			AllowCredentials: c.Security.AllowCredentials,
+			AllowedHeaders:   c.Security.AllowedHeaders,
+			MaxAge:           time.Duration(c.Security.CORSMaxAge),
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/charlinchui/galliard/message"
+	"github.com/charlinchui/galliard/server"
+	"github.com/charlinchui/galliard/transport"
+)
+
+func writeConfig(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+	return path
+}
+
+func TestLoadConfig_Defaults(t *testing.T) {
+	cfg, err := loadConfig("")
+	if err != nil {
+		t.Fatalf("loadConfig: %v", err)
+	}
+	if cfg.Listen != ":8080" || cfg.Paths.Bayeux != "/bayeux" {
+		t.Errorf("unexpected defaults: %+v", cfg)
+	}
+}
+
+func TestLoadConfig_YAML(t *testing.T) {
+	path := writeConfig(t, "galliard.yaml", `
+listen: ":9000"
+paths:
+  bayeux: /cometd
+  admin: /admin
+timeouts:
+  write: 90s
+  shutdown: 5s
+limits:
+  maxMessages: 10
+security:
+  allowedOrigins: ["https://app.example.com"]
+  adminToken: s3cret
+`)
+	cfg, err := loadConfig(path)
+	if err != nil {
+		t.Fatalf("loadConfig: %v", err)
+	}
+	if cfg.Listen != ":9000" || cfg.Paths.Bayeux != "/cometd" || cfg.Paths.Admin != "/admin" {
+		t.Errorf("unexpected config: %+v", cfg)
+	}
+	if time.Duration(cfg.Timeouts.Write) != 90*time.Second || time.Duration(cfg.Timeouts.Shutdown) != 5*time.Second {
+		t.Errorf("unexpected timeouts: %+v", cfg.Timeouts)
+	}
+	if len(cfg.Transports) != 1 || cfg.Transports[0] != "long-polling" {
+		t.Errorf("expected default transports, got %v", cfg.Transports)
+	}
+
+	srv := server.NewServer()
+	t.Cleanup(srv.Close)
+	h := transport.NewHTTPHandler(srv)
+	cfg.configureHandler(h)
+	if h.Limits.MaxMessages != 10 || h.Limits.MaxBodyBytes != transport.DefaultLimits().MaxBodyBytes {
+		t.Errorf("unexpected limits: %+v", h.Limits)
+	}
+	if h.CORS == nil || h.CORS.AllowedOrigins[0] != "https://app.example.com" {
+		t.Errorf("expected CORS to be configured, got %+v", h.CORS)
+	}
+}
+
+func TestLoadConfig_JSON(t *testing.T) {
+	path := writeConfig(t, "galliard.json", `{
+		"listen": "127.0.0.1:7000",
+		"tls": {"certFile": "cert.pem", "keyFile": "key.pem"},
+		"compression": {"disabled": true}
+	}`)
+	cfg, err := loadConfig(path)
+	if err != nil {
+		t.Fatalf("loadConfig: %v", err)
+	}
+	if cfg.Listen != "127.0.0.1:7000" || cfg.TLS.CertFile != "cert.pem" {
+		t.Errorf("unexpected config: %+v", cfg)
+	}
+	srv := server.NewServer()
+	t.Cleanup(srv.Close)
+	h := transport.NewHTTPHandler(srv)
+	cfg.configureHandler(h)
+	if h.Compression != nil {
+		t.Errorf("expected compression to be disabled")
+	}
+}
+
+func TestLoadConfig_Invalid(t *testing.T) {
+	cases := map[string]string{
+		"unknown.json":   `{"listen": ":1", "bogus": true}`,
+		"duration.yaml":  "timeouts:\n  read: soon\n",
+		"admin.yaml":     "paths:\n  admin: /admin\n",
+		"transport.yaml": "transports: [websocket]\n",
+		"tls.yaml":       "tls:\n  certFile: cert.pem\n",
+		"config.toml":    "listen = ':1'\n",
+		"write.yaml":     "advice:\n  timeout: 90s\n",
+		"interval.yaml":  "advice:\n  timeout: 20s\n  interval: 10s\ntimeouts:\n  write: 30s\n",
+		"rule.yaml":      "security:\n  rules:\n    - channel: admin\n",
+	}
+	for name, content := range cases {
+		if _, err := loadConfig(writeConfig(t, name, content)); err == nil {
+			t.Errorf("%s: expected error", name)
+		} else if !strings.Contains(err.Error(), "config") && !strings.Contains(err.Error(), "duration") {
+			t.Errorf("%s: unexpected error %v", name, err)
+		}
+	}
+}
+
+func TestLoadConfig_ServerOptions(t *testing.T) {
+	path := writeConfig(t, "galliard.yaml", `
+advice:
+  timeout: 20s
+  interval: 1s
+sessions:
+  timeout: 1m
+  maxQueue: 500
+  piggyback: true
+security:
+  rules:
+    - channel: /private/**
+`)
+	cfg, err := loadConfig(path)
+	if err != nil {
+		t.Fatalf("loadConfig: %v", err)
+	}
+	srv, err := server.New(cfg.serverOptions()...)
+	if err != nil {
+		t.Fatalf("server.New: %v", err)
+	}
+	t.Cleanup(srv.Close)
+
+	resp := srv.HandleMessage(&message.BayeuxMessage{Channel: "/meta/handshake"})
+	if resp.Advice.Timeout != 20000 || resp.Advice.Interval != 1000 {
+		t.Errorf("expected configured advice, got %+v", resp.Advice)
+	}
+	sub := srv.HandleMessage(&message.BayeuxMessage{
+		Channel:      "/meta/subscribe",
+		ClientID:     resp.ClientID,
+		Subscription: "/private/room",
+	})
+	if sub.Successful == nil || *sub.Successful {
+		t.Errorf("expected subscription to be denied by rule, got %+v", sub)
+	}
+}
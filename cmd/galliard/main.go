@@ -0,0 +1,130 @@
+// Command galliard runs a standalone Bayeux server.
+//
+// Usage:
+//
+//	galliard -config galliard.yaml
+//
+// The configuration file may be JSON or YAML; see Config for the available
+// settings. Without -config the server listens on :8080 and serves the Bayeux
+// endpoint at /bayeux. SIGINT or SIGTERM triggers a graceful shutdown.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/charlinchui/galliard/admin"
+	"github.com/charlinchui/galliard/server"
+	"github.com/charlinchui/galliard/transport"
+)
+
+func main() {
+	configPath := flag.String("config", "", "path to a JSON or YAML configuration file")
+	flag.Parse()
+
+	cfg, err := loadConfig(*configPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	srv, err := newServer(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer srv.Close()
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	ln, err := net.Listen("tcp", cfg.Listen)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := run(ctx, cfg, srv, ln); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// newServer creates the Bayeux server for cfg, reporting invalid server
+// settings as configuration errors.
+func newServer(cfg Config) (*server.Server, error) {
+	srv, err := server.New(append(cfg.serverOptions(), server.WithLogger(slog.Default()))...)
+	if err != nil {
+		return nil, fmt.Errorf("config: %w", err)
+	}
+	return srv, nil
+}
+
+// newMux mounts the Bayeux endpoint and, if configured, the admin API.
+func newMux(cfg Config, srv *server.Server) *http.ServeMux {
+	bayeux := transport.NewHTTPHandler(srv)
+	cfg.configureHandler(bayeux)
+
+	mux := http.NewServeMux()
+	mux.Handle(cfg.Paths.Bayeux, bayeux)
+	if cfg.Paths.Admin != "" {
+		prefix := strings.TrimSuffix(cfg.Paths.Admin, "/")
+		mux.Handle(prefix+"/", http.StripPrefix(prefix, admin.NewHandler(srv, admin.BearerToken(cfg.Security.AdminToken))))
+	}
+	return mux
+}
+
+// run serves srv on ln until ctx is cancelled, then shuts down gracefully.
+func run(ctx context.Context, cfg Config, srv *server.Server, ln net.Listener) error {
+	httpSrv := transport.NewHTTPServer(cfg.Listen, newMux(cfg, srv))
+	setTimeout(&httpSrv.ReadHeaderTimeout, cfg.Timeouts.ReadHeader)
+	setTimeout(&httpSrv.ReadTimeout, cfg.Timeouts.Read)
+	setTimeout(&httpSrv.WriteTimeout, cfg.Timeouts.Write)
+	setTimeout(&httpSrv.IdleTimeout, cfg.Timeouts.Idle)
+
+	// Held connects would otherwise keep Shutdown waiting for the full
+	// advice timeout; disconnecting the sessions releases them at once and
+	// tells clients to handshake again with the next instance.
+	httpSrv.RegisterOnShutdown(func() {
+		for _, info := range srv.SessionList() {
+			srv.Disconnect(info.ID)
+		}
+	})
+
+	errCh := make(chan error, 1)
+	go func() {
+		log.Printf("galliard listening on %s", ln.Addr())
+		if cfg.TLS.CertFile != "" {
+			errCh <- httpSrv.ServeTLS(ln, cfg.TLS.CertFile, cfg.TLS.KeyFile)
+		} else {
+			errCh <- httpSrv.Serve(ln)
+		}
+	}()
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+	}
+
+	log.Printf("galliard shutting down")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Duration(cfg.Timeouts.Shutdown))
+	defer cancel()
+	if err := httpSrv.Shutdown(shutdownCtx); err != nil {
+		return err
+	}
+	if err := <-errCh; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+func setTimeout(dst *time.Duration, d Duration) {
+	if d > 0 {
+		*dst = time.Duration(d)
+	}
+}
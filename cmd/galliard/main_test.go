@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/charlinchui/galliard/message"
+)
+
+func TestRun_ServesAndShutsDown(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.Paths.Admin = "/admin"
+	cfg.Security.AdminToken = "token"
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	srv, err := newServer(cfg)
+	if err != nil {
+		t.Fatalf("newServer: %v", err)
+	}
+	t.Cleanup(srv.Close)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- run(ctx, cfg, srv, ln) }()
+
+	base := "http://" + ln.Addr().String()
+	body, _ := json.Marshal([]message.BayeuxMessage{{Channel: "/meta/handshake"}})
+	resp, err := http.Post(base+"/bayeux", "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("post: %v", err)
+	}
+	var out []message.BayeuxMessage
+	json.NewDecoder(resp.Body).Decode(&out)
+	resp.Body.Close()
+	if len(out) != 1 || out[0].ClientID == "" {
+		t.Fatalf("unexpected handshake reply: %+v", out)
+	}
+	clientID := out[0].ClientID
+
+	req, _ := http.NewRequest(http.MethodGet, base+"/admin/sessions", nil)
+	req.Header.Set("Authorization", "Bearer token")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("admin: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("expected admin API to be mounted, got %d", resp.StatusCode)
+	}
+
+	// Leave a connect held so shutdown has to release it.
+	body, _ = json.Marshal([]message.BayeuxMessage{{Channel: "/meta/connect", ClientID: clientID}})
+	http.Post(base+"/bayeux", "application/json", bytes.NewReader(body))
+	go http.Post(base+"/bayeux", "application/json", bytes.NewReader(body))
+	time.Sleep(50 * time.Millisecond)
+
+	start := time.Now()
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("run: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("server did not shut down")
+	}
+	if time.Since(start) > 2*time.Second {
+		t.Errorf("shutdown waited for held connect")
+	}
+}
+
+func TestNewServer_InvalidServerOptions(t *testing.T) {
+	cases := map[string]string{
+		"advice.yaml":  "advice:\n  reconnect: maybe\n",
+		"session.yaml": "advice:\n  timeout: 30s\nsessions:\n  timeout: 10s\n",
+	}
+	for name, content := range cases {
+		cfg, err := loadConfig(writeConfig(t, name, content))
+		if err != nil {
+			t.Fatalf("%s: loadConfig: %v", name, err)
+		}
+		if _, err := newServer(cfg); err == nil || !strings.HasPrefix(err.Error(), "config: ") {
+			t.Errorf("%s: expected config error, got %v", name, err)
+		}
+	}
+}
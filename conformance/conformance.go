@@ -0,0 +1,469 @@
+// Package conformance checks a Bayeux endpoint against the Bayeux 1.0
+// specification.
+//
+// A Suite drives the endpoint through handshake, connect, subscribe,
+// publish, unsubscribe and disconnect scenarios, including their error
+// cases, using nothing but the wire protocol. It works with any transport
+// that can send a batch of messages and return the replies:
+//
+//	func TestConformance(t *testing.T) {
+//		ts := httptest.NewServer(handler)
+//		defer ts.Close()
+//		suite := conformance.Suite{
+//			NewTransport: func(t *testing.T) conformance.Transport {
+//				return &conformance.HTTPTransport{URL: ts.URL}
+//			},
+//		}
+//		suite.Run(t)
+//	}
+//
+// Some scenarios check that nothing is delivered, which takes a full held
+// /meta/connect; the endpoint should advise a connect timeout of a few
+// hundred milliseconds at most.
+package conformance
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"regexp"
+	"slices"
+	"sync/atomic"
+	"testing"
+
+	"github.com/charlinchui/galliard/message"
+)
+
+// Transport sends a batch of Bayeux messages to the endpoint under test and
+// returns the replies.
+type Transport interface {
+	Send(msgs ...*message.BayeuxMessage) ([]*message.BayeuxMessage, error)
+}
+
+// HTTPTransport sends batches as JSON arrays over HTTP POST, as the
+// long-polling transport does.
+type HTTPTransport struct {
+	URL string
+	// Client sends the requests. It defaults to http.DefaultClient.
+	Client *http.Client
+}
+
+// Send implements Transport.
+func (h *HTTPTransport) Send(msgs ...*message.BayeuxMessage) ([]*message.BayeuxMessage, error) {
+	body, err := json.Marshal(msgs)
+	if err != nil {
+		return nil, err
+	}
+	client := h.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Post(h.URL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("conformance: HTTP status %s", resp.Status)
+	}
+	var replies []*message.BayeuxMessage
+	if err := json.NewDecoder(resp.Body).Decode(&replies); err != nil {
+		return nil, fmt.Errorf("conformance: decoding replies: %w", err)
+	}
+	return replies, nil
+}
+
+// Suite is a set of conformance scenarios run against one endpoint.
+type Suite struct {
+	// NewTransport returns a transport to the endpoint under test.
+	// It is called once for every simulated client.
+	NewTransport func(t *testing.T) Transport
+
+	// Skip maps scenario names, such as "connect/delivers-queued", to the
+	// reason the endpoint is known not to pass them.
+	Skip map[string]string
+}
+
+type scenario struct {
+	name string
+	run  func(t *testing.T, s *Suite)
+}
+
+var scenarios = []scenario{
+	{"handshake/success", handshakeSuccess},
+	{"handshake/unique-client-ids", handshakeUniqueIDs},
+	{"handshake/unsupported-connection-type", handshakeUnsupportedType},
+	{"connect/success", connectSuccess},
+	{"connect/missing-client-id", connectMissingClientID},
+	{"connect/unknown-client", connectUnknownClient},
+	{"connect/delivers-queued", connectDeliversQueued},
+	{"subscribe/success", subscribeSuccess},
+	{"subscribe/missing-subscription", subscribeMissingSubscription},
+	{"subscribe/invalid-channel", subscribeInvalidChannel},
+	{"subscribe/meta-channel", subscribeMetaChannel},
+	{"subscribe/wildcards", subscribeWildcards},
+	{"publish/success", publishSuccess},
+	{"publish/wildcard-channel", publishWildcardChannel},
+	{"publish/meta-channel", publishMetaChannel},
+	{"publish/missing-data", publishMissingData},
+	{"unsubscribe/success", unsubscribeSuccess},
+	{"unsubscribe/missing-subscription", unsubscribeMissingSubscription},
+	{"disconnect/success", disconnectSuccess},
+	{"batch/replies-to-each", batchRepliesToEach},
+}
+
+// Run runs every scenario as a subtest of t.
+func (s *Suite) Run(t *testing.T) {
+	for _, sc := range scenarios {
+		t.Run(sc.name, func(t *testing.T) {
+			if reason, ok := s.Skip[sc.name]; ok {
+				t.Skip(reason)
+			}
+			sc.run(t, s)
+		})
+	}
+}
+
+// client is a simulated Bayeux client.
+type client struct {
+	t  *testing.T
+	tr Transport
+	id string
+	n  int
+}
+
+func (s *Suite) client(t *testing.T) *client {
+	return &client{t: t, tr: s.NewTransport(t)}
+}
+
+func (c *client) nextID() string {
+	c.n++
+	return fmt.Sprint(c.n)
+}
+
+func (c *client) send(msgs ...*message.BayeuxMessage) []*message.BayeuxMessage {
+	c.t.Helper()
+	replies, err := c.tr.Send(msgs...)
+	if err != nil {
+		c.t.Fatalf("Send failed: %v", err)
+	}
+	return replies
+}
+
+// request sends msg on its own, with a fresh id, and returns its reply.
+func (c *client) request(msg *message.BayeuxMessage) *message.BayeuxMessage {
+	c.t.Helper()
+	msg.ID = c.nextID()
+	return replyTo(c.t, c.send(msg), msg)
+}
+
+func (c *client) handshake() {
+	c.t.Helper()
+	resp := c.request(&message.BayeuxMessage{
+		Channel:                  "/meta/handshake",
+		Version:                  "1.0",
+		SupportedConnectionTypes: []string{"long-polling"},
+	})
+	successful(c.t, resp)
+	c.id = resp.ClientID
+}
+
+// connect sends a /meta/connect and returns the data messages delivered
+// with it, checking the connect reply itself.
+func (c *client) connect() []*message.BayeuxMessage {
+	c.t.Helper()
+	msg := &message.BayeuxMessage{Channel: "/meta/connect", ClientID: c.id, ConnectionType: "long-polling", ID: c.nextID()}
+	replies := c.send(msg)
+	successful(c.t, replyTo(c.t, replies, msg))
+	var data []*message.BayeuxMessage
+	for _, r := range replies {
+		if !isMeta(r.Channel) {
+			data = append(data, r)
+		}
+	}
+	return data
+}
+
+// receive connects until n data messages have been delivered.
+func (c *client) receive(n int) []*message.BayeuxMessage {
+	c.t.Helper()
+	var data []*message.BayeuxMessage
+	for i := 0; i < n+2 && len(data) < n; i++ {
+		data = append(data, c.connect()...)
+	}
+	if len(data) != n {
+		c.t.Fatalf("Expected %d delivered messages, got %d", n, len(data))
+	}
+	return data
+}
+
+func (c *client) subscribe(ch string) {
+	c.t.Helper()
+	successful(c.t, c.request(&message.BayeuxMessage{Channel: "/meta/subscribe", ClientID: c.id, Subscription: ch}))
+}
+
+func (c *client) publish(ch string, data interface{}) {
+	c.t.Helper()
+	successful(c.t, c.request(&message.BayeuxMessage{Channel: ch, ClientID: c.id, Data: message.MustData(data)}))
+}
+
+// handshaken returns a client that has handshaken and made its first connect.
+func (s *Suite) handshaken(t *testing.T) *client {
+	t.Helper()
+	c := s.client(t)
+	c.handshake()
+	c.connect()
+	return c
+}
+
+var channelSeq atomic.Int64
+
+// uniqueChannel returns a channel name no other scenario uses.
+func uniqueChannel(name string) string {
+	return fmt.Sprintf("/conformance/%d/%s", channelSeq.Add(1), name)
+}
+
+func isMeta(ch string) bool {
+	return len(ch) >= 6 && ch[:6] == "/meta/"
+}
+
+// replyTo finds the reply to msg among replies.
+func replyTo(t *testing.T, replies []*message.BayeuxMessage, msg *message.BayeuxMessage) *message.BayeuxMessage {
+	t.Helper()
+	for _, r := range replies {
+		if r.Channel == msg.Channel && r.ID == msg.ID && r.Successful != nil {
+			return r
+		}
+	}
+	t.Fatalf("No reply to %s with id %q in %s", msg.Channel, msg.ID, dump(replies))
+	return nil
+}
+
+// errorFormat matches the "code:args:message" form of Bayeux errors.
+var errorFormat = regexp.MustCompile(`^\d{3}:[^:]*:.`)
+
+func successful(t *testing.T, resp *message.BayeuxMessage) {
+	t.Helper()
+	if resp.Successful == nil || !*resp.Successful {
+		t.Fatalf("Expected successful reply, got %s", dump([]*message.BayeuxMessage{resp}))
+	}
+}
+
+func unsuccessful(t *testing.T, resp *message.BayeuxMessage) {
+	t.Helper()
+	if resp.Successful == nil || *resp.Successful {
+		t.Fatalf("Expected unsuccessful reply, got %s", dump([]*message.BayeuxMessage{resp}))
+	}
+	if !errorFormat.MatchString(resp.Error) {
+		t.Errorf("Expected error in code:args:message form, got %q", resp.Error)
+	}
+}
+
+func sameJSON(a, b json.RawMessage) bool {
+	var va, vb interface{}
+	if json.Unmarshal(a, &va) != nil || json.Unmarshal(b, &vb) != nil {
+		return false
+	}
+	return reflect.DeepEqual(va, vb)
+}
+
+func dump(msgs []*message.BayeuxMessage) string {
+	b, _ := json.Marshal(msgs)
+	return string(b)
+}
+
+func handshakeSuccess(t *testing.T, s *Suite) {
+	c := s.client(t)
+	msg := &message.BayeuxMessage{Channel: "/meta/handshake", Version: "1.0", SupportedConnectionTypes: []string{"long-polling"}, ID: "h1"}
+	resp := replyTo(t, c.send(msg), msg)
+	successful(t, resp)
+	if resp.ClientID == "" {
+		t.Error("Expected clientId in handshake reply")
+	}
+	if resp.Version == "" {
+		t.Error("Expected version in handshake reply")
+	}
+	if !slices.Contains(resp.SupportedConnectionTypes, "long-polling") {
+		t.Errorf("Expected long-polling among supported connection types, got %v", resp.SupportedConnectionTypes)
+	}
+}
+
+func handshakeUniqueIDs(t *testing.T, s *Suite) {
+	a, b := s.client(t), s.client(t)
+	a.handshake()
+	b.handshake()
+	if a.id == b.id {
+		t.Errorf("Expected distinct client IDs, got %q twice", a.id)
+	}
+}
+
+func handshakeUnsupportedType(t *testing.T, s *Suite) {
+	c := s.client(t)
+	unsuccessful(t, c.request(&message.BayeuxMessage{
+		Channel:                  "/meta/handshake",
+		Version:                  "1.0",
+		SupportedConnectionTypes: []string{"carrier-pigeon"},
+	}))
+}
+
+func connectSuccess(t *testing.T, s *Suite) {
+	c := s.client(t)
+	c.handshake()
+	resp := c.request(&message.BayeuxMessage{Channel: "/meta/connect", ClientID: c.id, ConnectionType: "long-polling"})
+	successful(t, resp)
+	if resp.ClientID != c.id {
+		t.Errorf("Expected clientId %q in connect reply, got %q", c.id, resp.ClientID)
+	}
+}
+
+func connectMissingClientID(t *testing.T, s *Suite) {
+	c := s.client(t)
+	unsuccessful(t, c.request(&message.BayeuxMessage{Channel: "/meta/connect", ConnectionType: "long-polling"}))
+}
+
+func connectUnknownClient(t *testing.T, s *Suite) {
+	c := s.client(t)
+	resp := c.request(&message.BayeuxMessage{Channel: "/meta/connect", ClientID: "no-such-client", ConnectionType: "long-polling"})
+	unsuccessful(t, resp)
+	if resp.Advice == nil || resp.Advice.Reconnect != "handshake" {
+		t.Errorf("Expected advice to handshake again, got %+v", resp.Advice)
+	}
+}
+
+func connectDeliversQueued(t *testing.T, s *Suite) {
+	ch := uniqueChannel("queued")
+	sub, pub := s.handshaken(t), s.handshaken(t)
+	sub.subscribe(ch)
+	for i := 0; i < 3; i++ {
+		pub.publish(ch, i)
+	}
+	for i, msg := range sub.receive(3) {
+		if msg.Channel != ch || !sameJSON(msg.Data, message.MustData(i)) {
+			t.Errorf("Expected message %d on %s, got %s", i, ch, dump([]*message.BayeuxMessage{msg}))
+		}
+	}
+}
+
+func subscribeSuccess(t *testing.T, s *Suite) {
+	c := s.handshaken(t)
+	ch := uniqueChannel("subscribe")
+	resp := c.request(&message.BayeuxMessage{Channel: "/meta/subscribe", ClientID: c.id, Subscription: ch})
+	successful(t, resp)
+	if resp.Subscription != ch {
+		t.Errorf("Expected subscription %q echoed, got %q", ch, resp.Subscription)
+	}
+}
+
+func subscribeMissingSubscription(t *testing.T, s *Suite) {
+	c := s.handshaken(t)
+	unsuccessful(t, c.request(&message.BayeuxMessage{Channel: "/meta/subscribe", ClientID: c.id}))
+}
+
+func subscribeInvalidChannel(t *testing.T, s *Suite) {
+	c := s.handshaken(t)
+	for _, ch := range []string{"no-leading-slash", "/empty//segment", "/wild/*/middle"} {
+		unsuccessful(t, c.request(&message.BayeuxMessage{Channel: "/meta/subscribe", ClientID: c.id, Subscription: ch}))
+	}
+}
+
+func subscribeMetaChannel(t *testing.T, s *Suite) {
+	c := s.handshaken(t)
+	unsuccessful(t, c.request(&message.BayeuxMessage{Channel: "/meta/subscribe", ClientID: c.id, Subscription: "/meta/connect"}))
+}
+
+func subscribeWildcards(t *testing.T, s *Suite) {
+	base := uniqueChannel("wild")
+	sub, pub := s.handshaken(t), s.handshaken(t)
+	sub.subscribe(base + "/one/*")
+	sub.subscribe(base + "/all/**")
+	pub.publish(base+"/one/a/deep", "not matched")
+	pub.publish(base+"/one/a", "one")
+	pub.publish(base+"/all/a/b", "all")
+
+	got := map[string]bool{}
+	for _, msg := range sub.receive(2) {
+		got[msg.Channel] = true
+	}
+	if !got[base+"/one/a"] || !got[base+"/all/a/b"] {
+		t.Errorf("Expected messages matching both wildcards, got %v", got)
+	}
+}
+
+func publishSuccess(t *testing.T, s *Suite) {
+	ch := uniqueChannel("publish")
+	sub, pub := s.handshaken(t), s.handshaken(t)
+	sub.subscribe(ch)
+	data := message.MustData(map[string]interface{}{"text": "hello", "n": 1})
+	resp := pub.request(&message.BayeuxMessage{
+		Channel:  ch,
+		ClientID: pub.id,
+		Data:     data,
+		Ext:      map[string]json.RawMessage{"credentials": message.MustData("secret")},
+	})
+	successful(t, resp)
+
+	msg := sub.receive(1)[0]
+	if msg.Channel != ch || !sameJSON(msg.Data, data) {
+		t.Errorf("Expected published data on %s, got %s", ch, dump([]*message.BayeuxMessage{msg}))
+	}
+	if msg.ClientID != "" || msg.Ext != nil {
+		t.Errorf("Expected the publisher's clientId and ext not to be delivered, got %s", dump([]*message.BayeuxMessage{msg}))
+	}
+}
+
+func publishWildcardChannel(t *testing.T, s *Suite) {
+	c := s.handshaken(t)
+	unsuccessful(t, c.request(&message.BayeuxMessage{Channel: uniqueChannel("wild") + "/*", ClientID: c.id, Data: message.MustData(1)}))
+}
+
+func publishMetaChannel(t *testing.T, s *Suite) {
+	c := s.handshaken(t)
+	unsuccessful(t, c.request(&message.BayeuxMessage{Channel: "/meta/custom", ClientID: c.id, Data: message.MustData(1)}))
+}
+
+func publishMissingData(t *testing.T, s *Suite) {
+	c := s.handshaken(t)
+	unsuccessful(t, c.request(&message.BayeuxMessage{Channel: uniqueChannel("nodata"), ClientID: c.id}))
+}
+
+func unsubscribeSuccess(t *testing.T, s *Suite) {
+	ch := uniqueChannel("unsubscribe")
+	sub, pub := s.handshaken(t), s.handshaken(t)
+	sub.subscribe(ch)
+	resp := sub.request(&message.BayeuxMessage{Channel: "/meta/unsubscribe", ClientID: sub.id, Subscription: ch})
+	successful(t, resp)
+	if resp.Subscription != ch {
+		t.Errorf("Expected subscription %q echoed, got %q", ch, resp.Subscription)
+	}
+	pub.publish(ch, "after unsubscribe")
+	if data := sub.connect(); len(data) != 0 {
+		t.Errorf("Expected no delivery after unsubscribe, got %s", dump(data))
+	}
+}
+
+func unsubscribeMissingSubscription(t *testing.T, s *Suite) {
+	c := s.handshaken(t)
+	unsuccessful(t, c.request(&message.BayeuxMessage{Channel: "/meta/unsubscribe", ClientID: c.id}))
+}
+
+func disconnectSuccess(t *testing.T, s *Suite) {
+	c := s.handshaken(t)
+	successful(t, c.request(&message.BayeuxMessage{Channel: "/meta/disconnect", ClientID: c.id}))
+	resp := c.request(&message.BayeuxMessage{Channel: "/meta/connect", ClientID: c.id, ConnectionType: "long-polling"})
+	unsuccessful(t, resp)
+	if resp.Advice == nil || resp.Advice.Reconnect != "handshake" {
+		t.Errorf("Expected advice to handshake again after disconnect, got %+v", resp.Advice)
+	}
+}
+
+func batchRepliesToEach(t *testing.T, s *Suite) {
+	c := s.handshaken(t)
+	ch := uniqueChannel("batch")
+	subscribe := &message.BayeuxMessage{Channel: "/meta/subscribe", ClientID: c.id, Subscription: ch, ID: c.nextID()}
+	publish := &message.BayeuxMessage{Channel: ch, ClientID: c.id, Data: message.MustData("batched"), ID: c.nextID()}
+	replies := c.send(subscribe, publish)
+	successful(t, replyTo(t, replies, subscribe))
+	successful(t, replyTo(t, replies, publish))
+}
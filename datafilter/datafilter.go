@@ -0,0 +1,190 @@
+// Package datafilter sanitizes and validates the data of published
+// messages before the server delivers them.
+//
+// Filters are bound to channel patterns with Bind:
+//
+//	srv, err := server.New(
+//		datafilter.Bind("/chat/**",
+//			datafilter.RequireFields("user", "text"),
+//			datafilter.MaxLength(500),
+//			datafilter.EscapeHTML(),
+//		),
+//	)
+//
+// A filter that returns an error rejects the message; the publisher
+// receives a "403:<channel>:<error>" reply.
+package datafilter
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/charlinchui/galliard/internal/channel"
+	"github.com/charlinchui/galliard/message"
+	"github.com/charlinchui/galliard/server"
+)
+
+// Errors returned by the built-in filters.
+var (
+	ErrTooLong      = errors.New("string too long")
+	ErrMissingField = errors.New("missing field")
+)
+
+// Filter transforms the data of a message published to channel, returning
+// the data to publish in its place or an error to reject the message.
+//
+// Data is decoded as by encoding/json, except that numbers are
+// json.Numbers so they are republished unchanged. Filters may modify data
+// in place.
+type Filter interface {
+	Filter(ctx context.Context, channel string, data interface{}) (interface{}, error)
+}
+
+// FilterFunc adapts a function to the Filter interface.
+type FilterFunc func(ctx context.Context, channel string, data interface{}) (interface{}, error)
+
+func (f FilterFunc) Filter(ctx context.Context, channel string, data interface{}) (interface{}, error) {
+	return f(ctx, channel, data)
+}
+
+// Chain runs filters in order, each receiving the data returned by the
+// previous one.
+type Chain []Filter
+
+// Apply decodes data, runs the chain over it and returns the result
+// encoded again, or the first error a filter returns.
+func (c Chain) Apply(ctx context.Context, channel string, data json.RawMessage) (json.RawMessage, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, fmt.Errorf("invalid data: %w", err)
+	}
+	for _, f := range c {
+		var err error
+		if v, err = f.Filter(ctx, channel, v); err != nil {
+			return nil, err
+		}
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+// Interceptor returns a server.PublishInterceptor that replaces the data
+// of each message with the result of the chain.
+func (c Chain) Interceptor() server.PublishInterceptor {
+	return func(ctx context.Context, ch *server.Channel, msg *message.BayeuxMessage) error {
+		data, err := c.Apply(ctx, ch.Name(), msg.Data)
+		if err != nil {
+			return err
+		}
+		msg.Data = data
+		return nil
+	}
+}
+
+// Bind returns a server option that runs filters, in order, on messages
+// published to channels matching pattern, before they are delivered.
+// Patterns may end in "/*" or "/**". Chains bound to the same channel run
+// in the order they were bound.
+func Bind(pattern string, filters ...Filter) server.Option {
+	chain := Chain(filters).Interceptor()
+	return server.WithChannelInitializer(func(ch *server.Channel) {
+		if channel.Match(pattern, ch.Name()) {
+			ch.AddPublishInterceptor(chain)
+		}
+	})
+}
+
+// EscapeHTML returns a filter that escapes HTML markup in every string in
+// the data, so it displays as text rather than being interpreted by
+// browsers.
+func EscapeHTML() Filter {
+	return replaceStrings(html.EscapeString)
+}
+
+// Replace returns a filter that replaces matches of re in every string in
+// the data with repl, as by regexp.Regexp.ReplaceAllString.
+func Replace(re *regexp.Regexp, repl string) Filter {
+	return replaceStrings(func(s string) string {
+		return re.ReplaceAllString(s, repl)
+	})
+}
+
+// MaxLength returns a filter that rejects data containing a string longer
+// than n characters.
+func MaxLength(n int) Filter {
+	return FilterFunc(func(_ context.Context, _ string, data interface{}) (interface{}, error) {
+		var err error
+		walkStrings(data, func(s string) string {
+			if err == nil && utf8.RuneCountInString(s) > n {
+				err = fmt.Errorf("%w: more than %d characters", ErrTooLong, n)
+			}
+			return s
+		})
+		return data, err
+	})
+}
+
+// RequireFields returns a filter that rejects data that is not an object
+// with all of fields. Fields are dotted paths into nested objects, such as
+// "user.name"; a field present with a null value counts as missing.
+func RequireFields(fields ...string) Filter {
+	return FilterFunc(func(_ context.Context, _ string, data interface{}) (interface{}, error) {
+		for _, field := range fields {
+			if !hasField(data, field) {
+				return nil, fmt.Errorf("%w %s", ErrMissingField, field)
+			}
+		}
+		return data, nil
+	})
+}
+
+func hasField(data interface{}, path string) bool {
+	for _, name := range strings.Split(path, ".") {
+		obj, ok := data.(map[string]interface{})
+		if !ok {
+			return false
+		}
+		if data = obj[name]; data == nil {
+			return false
+		}
+	}
+	return true
+}
+
+func replaceStrings(f func(string) string) Filter {
+	return FilterFunc(func(_ context.Context, _ string, data interface{}) (interface{}, error) {
+		return walkStrings(data, f), nil
+	})
+}
+
+// walkStrings replaces every string value in data, but not object keys,
+// with the result of f, returning the updated data.
+func walkStrings(data interface{}, f func(string) string) interface{} {
+	switch v := data.(type) {
+	case string:
+		return f(v)
+	case map[string]interface{}:
+		for key, elem := range v {
+			v[key] = walkStrings(elem, f)
+		}
+	case []interface{}:
+		for i, elem := range v {
+			v[i] = walkStrings(elem, f)
+		}
+	}
+	return data
+}
@@ -0,0 +1,80 @@
+package datafilter
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"regexp"
+	"testing"
+
+	"github.com/charlinchui/galliard/message"
+	"github.com/charlinchui/galliard/server"
+)
+
+func apply(t *testing.T, data string, filters ...Filter) (string, error) {
+	t.Helper()
+	out, err := Chain(filters).Apply(context.Background(), "/chat", json.RawMessage(data))
+	return string(out), err
+}
+
+func TestBuiltinFilters(t *testing.T) {
+	cases := []struct {
+		name    string
+		filters []Filter
+		data    string
+		want    string
+	}{
+		{"escape", []Filter{EscapeHTML()}, `{"<b>":["<b>hi</b>",1],"n":{"s":"a&b"}}`, `{"<b>":["&lt;b&gt;hi&lt;/b&gt;",1],"n":{"s":"a&amp;b"}}`},
+		{"replace", []Filter{Replace(regexp.MustCompile(`(?i)darn`), "****")}, `{"text":"Darn it, darn"}`, `{"text":"**** it, ****"}`},
+		{"numbers", []Filter{EscapeHTML()}, `{"big":12345678901234567890,"f":1.50}`, `{"big":12345678901234567890,"f":1.50}`},
+		{"max length", []Filter{MaxLength(5)}, `{"text":"héllo"}`, `{"text":"héllo"}`},
+		{"required", []Filter{RequireFields("user.name", "text")}, `{"user":{"name":"ann"},"text":""}`, `{"text":"","user":{"name":"ann"}}`},
+		{"chain order", []Filter{Replace(regexp.MustCompile(`x`), "<"), EscapeHTML()}, `"x"`, `"&lt;"`},
+	}
+	for _, c := range cases {
+		got, err := apply(t, c.data, c.filters...)
+		if err != nil || got != c.want {
+			t.Errorf("%s: expected %s, got %s (%v)", c.name, c.want, got, err)
+		}
+	}
+}
+
+func TestRejectingFilters(t *testing.T) {
+	if _, err := apply(t, `{"list":["ok","too long"]}`, MaxLength(5)); !errors.Is(err, ErrTooLong) {
+		t.Errorf("Expected ErrTooLong, got %v", err)
+	}
+	for _, data := range []string{`{"user":{}}`, `{"user":{"name":null}}`, `"text"`} {
+		if _, err := apply(t, data, RequireFields("user.name")); !errors.Is(err, ErrMissingField) || err.Error() != "missing field user.name" {
+			t.Errorf("%s: expected missing field error, got %v", data, err)
+		}
+	}
+}
+
+func TestBind(t *testing.T) {
+	srv, err := server.New(Bind("/chat/**", RequireFields("text"), EscapeHTML()))
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer srv.Close()
+	clientID := srv.HandleMessage(&message.BayeuxMessage{Channel: "/meta/handshake"}).ClientID
+	for _, sub := range []string{"/chat/room", "/raw"} {
+		srv.HandleMessage(&message.BayeuxMessage{Channel: "/meta/subscribe", ClientID: clientID, Subscription: sub})
+	}
+
+	resp := srv.HandleMessage(&message.BayeuxMessage{Channel: "/chat/room", ClientID: clientID, Data: json.RawMessage(`{"other":1}`)})
+	if resp.Error != "403:/chat/room:missing field text" {
+		t.Errorf("Expected message without text to be rejected, got %+v", resp)
+	}
+	srv.HandleMessage(&message.BayeuxMessage{Channel: "/chat/room", ClientID: clientID, Data: json.RawMessage(`{"text":"<i>hi</i>"}`)})
+	srv.HandleMessage(&message.BayeuxMessage{Channel: "/raw", ClientID: clientID, Data: json.RawMessage(`{"text":"<i>hi</i>"}`)})
+	srv.Close()
+
+	msgs := srv.HandleMessages([]*message.BayeuxMessage{{Channel: "/meta/connect", ClientID: clientID}})
+	data := map[string]string{}
+	for _, msg := range msgs {
+		data[msg.Channel] = string(msg.Data)
+	}
+	if len(msgs) != 3 || data["/chat/room"] != `{"text":"&lt;i&gt;hi&lt;/i&gt;"}` || data["/raw"] != `{"text":"<i>hi</i>"}` {
+		t.Errorf("Expected only the chat message to be escaped, got %v", data)
+	}
+}
@@ -1,3 +1,5 @@
 module github.com/charlinchui/galliard
 
 go 1.23.8
+
+require gopkg.in/yaml.v3 v3.0.1
@@ -1,41 +1,288 @@
 package channel
 
 import (
+	"encoding/json"
+	"sort"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/charlinchui/galliard/internal/client"
+	"github.com/charlinchui/galliard/internal/selector"
 	"github.com/charlinchui/galliard/message"
 )
 
 type Channel struct {
 	Name        string
 	Subscribers map[string]*client.Session
-	mu          sync.Mutex
+	// Lazy makes every message published to the channel lazy.
+	Lazy bool
+	// Conflate makes subscriber queues keep only the newest message for
+	// each conflation key. On other channels, conflation keys are ignored.
+	Conflate bool
+	// Retain makes the channel keep the last message published to it and
+	// deliver it to each new subscriber.
+	Retain bool
+	// retained is the last message published to a Retain channel.
+	retained *message.BayeuxMessage
+	// filters holds the selector of each subscriber that subscribed with a
+	// filter expression.
+	filters map[string]*selector.Selector
+	// snapshot holds Subscribers as a slice for Publish. It is rebuilt
+	// under mu whenever the subscriber set changes and never modified once
+	// stored, so Publish can load and iterate it without taking mu.
+	snapshot atomic.Pointer[[]subscriber]
+	// lastActive is when the channel was last used, in Unix nanoseconds.
+	lastActive atomic.Int64
+	persistent atomic.Bool
+	ttl        atomic.Int64
+	removed    bool
+	mu         sync.Mutex
+	hooks      hooks
+}
+
+// subscriber is a session in the Publish snapshot with its filter, if any.
+type subscriber struct {
+	session *client.Session
+	filter  *selector.Selector
 }
 
 func NewChannel(name string) *Channel {
 	return &Channel{
 		Name:        name,
 		Subscribers: make(map[string]*client.Session),
+		filters:     make(map[string]*selector.Selector),
 	}
 }
 
-func (ch *Channel) Subscribe(s *client.Session) {
+// Subscribe adds s to the channel's subscribers. It reports false, and does
+// nothing, if the channel has been removed.
+// Subscription listeners are notified if s was not already subscribed.
+func (ch *Channel) Subscribe(s *client.Session) bool {
+	return ch.SubscribeFiltered(s, nil)
+}
+
+// SubscribeFiltered is like Subscribe, but s only receives messages whose
+// data matches filter. A nil filter matches every message. Subscribing
+// again replaces the previous filter.
+// A new subscriber is sent the retained message, if any and if it matches,
+// before any message published after it.
+func (ch *Channel) SubscribeFiltered(s *client.Session, filter *selector.Selector) bool {
 	ch.mu.Lock()
-	defer ch.mu.Unlock()
+	if ch.removed {
+		ch.mu.Unlock()
+		return false
+	}
+	_, existed := ch.Subscribers[s.ID]
 	ch.Subscribers[s.ID] = s
+	if filter != nil {
+		ch.filters[s.ID] = filter
+	} else {
+		delete(ch.filters, s.ID)
+	}
+	ch.rebuildSnapshotLocked()
+	if !existed && ch.retained != nil && matches(filter, ch.retained, nil) {
+		// Enqueued under mu, so that a concurrent Publish cannot deliver
+		// a newer message first.
+		s.Enqueue(ch.retained)
+	}
+	ch.mu.Unlock()
+	if !existed {
+		ch.notifySubscription(s.ID, true)
+	}
+	return true
 }
 
+// Unsubscribe removes s from the channel's subscribers, notifying
+// subscription listeners if it was subscribed.
 func (ch *Channel) Unsubscribe(s *client.Session) {
+	ch.mu.Lock()
+	_, existed := ch.Subscribers[s.ID]
+	if existed {
+		delete(ch.Subscribers, s.ID)
+		delete(ch.filters, s.ID)
+		ch.rebuildSnapshotLocked()
+	}
+	ch.mu.Unlock()
+	if existed {
+		ch.notifySubscription(s.ID, false)
+	}
+}
+
+// Publish enqueues msg for every subscriber of ch and of wildcards, the
+// wildcard channels whose patterns match ch's name. A session subscribed
+// to several of them receives msg once, if any of its subscriptions'
+// filters match. It iterates copy-on-write subscriber snapshots, so a large
+// fan-out neither holds up Subscribe and Unsubscribe nor waits for them.
+func (ch *Channel) Publish(msg *message.BayeuxMessage, wildcards ...*Channel) {
+	lazy := ch.Lazy || msg.Lazy
+	if msg.ConflationKey != "" && !ch.Conflate {
+		m := *msg
+		m.ConflationKey = ""
+		msg = &m
+	}
+	var seen map[string]bool
+	if len(wildcards) > 0 {
+		seen = make(map[string]bool)
+	}
+	var data interface{}
+	for i, c := range append([]*Channel{ch}, wildcards...) {
+		var subs []subscriber
+		if i == 0 && ch.Retain {
+			subs = ch.retain(msg)
+		} else {
+			subs = c.subscribers()
+		}
+		for _, sub := range subs {
+			s := sub.session
+			if seen != nil && seen[s.ID] {
+				continue
+			}
+			if !matches(sub.filter, msg, &data) {
+				continue
+			}
+			if seen != nil {
+				seen[s.ID] = true
+			}
+			if lazy {
+				s.EnqueueLazy(msg)
+			} else {
+				s.Enqueue(msg)
+			}
+		}
+	}
+}
+
+// matches reports whether msg passes filter. data caches the decoded
+// message data across calls; it may be nil to not cache it.
+func matches(filter *selector.Selector, msg *message.BayeuxMessage, data *interface{}) bool {
+	if filter == nil {
+		return true
+	}
+	if data == nil {
+		data = new(interface{})
+	}
+	if *data == nil {
+		// Data that is not valid JSON decodes to nil and matches no field
+		// predicate.
+		_ = json.Unmarshal(msg.Data, data)
+	}
+	return filter.Match(*data)
+}
+
+// retain makes msg the retained message and returns the subscriber
+// snapshot in the same step, so every subscriber receives either msg from
+// Publish or, subscribing later, as the retained message.
+func (ch *Channel) retain(msg *message.BayeuxMessage) []subscriber {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	ch.retained = msg
+	return ch.subscribers()
+}
+
+// Retained returns the retained message, or nil if there is none.
+func (ch *Channel) Retained() *message.BayeuxMessage {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	return ch.retained
+}
+
+// SetRetained replaces the retained message, which is cleared if msg is
+// nil, without delivering it.
+func (ch *Channel) SetRetained(msg *message.BayeuxMessage) {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	ch.retained = msg
+}
+
+// subscribers returns the current subscriber snapshot.
+func (ch *Channel) subscribers() []subscriber {
+	if subs := ch.snapshot.Load(); subs != nil {
+		return *subs
+	}
+	return nil
+}
+
+// rebuildSnapshotLocked replaces the subscriber snapshot after the
+// subscriber set changed. ch.mu must be held.
+func (ch *Channel) rebuildSnapshotLocked() {
+	subs := make([]subscriber, 0, len(ch.Subscribers))
+	for id, s := range ch.Subscribers {
+		subs = append(subs, subscriber{session: s, filter: ch.filters[id]})
+	}
+	ch.snapshot.Store(&subs)
+}
+
+// Touch records t as the last time the channel was used.
+func (ch *Channel) Touch(t time.Time) {
+	ch.lastActive.Store(t.UnixNano())
+}
+
+// LastActive returns the last time recorded by Touch.
+func (ch *Channel) LastActive() time.Time {
+	return time.Unix(0, ch.lastActive.Load())
+}
+
+// SetPersistent marks whether the channel is kept when unused.
+func (ch *Channel) SetPersistent(persistent bool) {
+	ch.persistent.Store(persistent)
+}
+
+// Persistent reports whether the channel is kept when unused.
+func (ch *Channel) Persistent() bool {
+	return ch.persistent.Load()
+}
+
+// SetTTL sets how long messages published to the channel stay deliverable.
+// Zero means they do not expire.
+func (ch *Channel) SetTTL(ttl time.Duration) {
+	ch.ttl.Store(int64(ttl))
+}
+
+// TTL returns how long messages published to the channel stay deliverable.
+func (ch *Channel) TTL() time.Duration {
+	return time.Duration(ch.ttl.Load())
+}
+
+// Remove marks the channel removed if it is not persistent, holds no
+// retained message, has no subscribers and was last active before
+// idleSince, reporting whether it did. A removed channel accepts no further
+// subscribers.
+func (ch *Channel) Remove(idleSince time.Time) bool {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	if ch.removed {
+		return true
+	}
+	if ch.Persistent() || ch.retained != nil || len(ch.Subscribers) > 0 || !ch.LastActive().Before(idleSince) {
+		return false
+	}
+	ch.removed = true
+	return true
+}
+
+// Removed reports whether Remove has removed the channel.
+func (ch *Channel) Removed() bool {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	return ch.removed
+}
+
+// SubscriberCount returns the number of sessions subscribed to the channel.
+func (ch *Channel) SubscriberCount() int {
 	ch.mu.Lock()
 	defer ch.mu.Unlock()
-	delete(ch.Subscribers, s.ID)
+	return len(ch.Subscribers)
 }
 
-func (ch *Channel) Publish(msg *message.BayeuxMessage) {
+// SubscriberIDs returns the IDs of subscribed sessions in sorted order.
+func (ch *Channel) SubscriberIDs() []string {
 	ch.mu.Lock()
 	defer ch.mu.Unlock()
-	for _, s := range ch.Subscribers {
-		s.Enqueue(msg)
+	ids := make([]string, 0, len(ch.Subscribers))
+	for id := range ch.Subscribers {
+		ids = append(ids, id)
 	}
+	sort.Strings(ids)
+	return ids
 }
@@ -2,8 +2,10 @@ package channel
 
 import (
 	"testing"
+	"time"
 
 	"github.com/charlinchui/galliard/internal/client"
+	"github.com/charlinchui/galliard/internal/selector"
 	"github.com/charlinchui/galliard/message"
 )
 
@@ -50,9 +52,7 @@ func TestPublish(t *testing.T) {
 	ch.Subscribe(s2)
 	msg := &message.BayeuxMessage{
 		Channel: "/baz",
-		Data: map[string]interface{}{
-			"x": 1.0,
-		},
+		Data:    message.MustData(map[string]interface{}{"x": 1.0}),
 	}
 	ch.Publish(msg)
 	msgs1 := s1.DequeueAll()
@@ -64,7 +64,216 @@ func TestPublish(t *testing.T) {
 	if msgs1[0].Channel != "/baz" || msgs2[0].Channel != "/baz" {
 		t.Errorf("Expected channel to be '/baz'")
 	}
-	if msgs1[0].Data["x"] != 1.0 || msgs2[0].Data["x"] != 1.0 {
+	if string(msgs1[0].Data) != `{"x":1}` || string(msgs2[0].Data) != `{"x":1}` {
 		t.Errorf("Expected data for 'x' to be 1.0")
 	}
 }
+
+func TestPublishLazy(t *testing.T) {
+	ch := NewChannel("/ticks")
+	ch.Lazy = true
+	s := newTestSession("c1")
+	s.MaxLazy = time.Hour
+	ch.Subscribe(s)
+	ch.Publish(&message.BayeuxMessage{Channel: "/ticks"})
+	select {
+	case <-s.Wake():
+		t.Errorf("Expected lazy channel not to wake subscribers")
+	default:
+	}
+
+	plain := NewChannel("/alerts")
+	plain.Subscribe(s)
+	plain.Publish(&message.BayeuxMessage{Channel: "/alerts", Lazy: true})
+	select {
+	case <-s.Wake():
+		t.Errorf("Expected lazy message not to wake subscribers")
+	default:
+	}
+	if n := s.QueueLen(); n != 2 {
+		t.Errorf("Expected 2 queued messages, got %d", n)
+	}
+	s.Close()
+}
+
+func TestPublishUsesSnapshot(t *testing.T) {
+	ch := NewChannel("/snap")
+	s1 := newTestSession("c1")
+	ch.Subscribe(s1)
+	ch.Publish(&message.BayeuxMessage{Channel: "/snap", ID: "1"})
+
+	s2 := newTestSession("c2")
+	ch.Subscribe(s2)
+	ch.Publish(&message.BayeuxMessage{Channel: "/snap", ID: "2"})
+	ch.Unsubscribe(s1)
+	ch.Publish(&message.BayeuxMessage{Channel: "/snap", ID: "3"})
+
+	if n := s1.QueueLen(); n != 2 {
+		t.Errorf("Expected c1 to receive 2 messages, got %d", n)
+	}
+	if n := s2.QueueLen(); n != 2 {
+		t.Errorf("Expected c2 to receive 2 messages, got %d", n)
+	}
+}
+
+func TestPublishDoesNotTakeChannelLock(t *testing.T) {
+	ch := NewChannel("/snap")
+	s1 := newTestSession("c1")
+	ch.Subscribe(s1)
+
+	ch.mu.Lock()
+	done := make(chan struct{})
+	go func() {
+		ch.Publish(&message.BayeuxMessage{Channel: "/snap", ID: "1"})
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Expected Publish not to wait for the channel lock")
+	}
+	ch.mu.Unlock()
+	if n := s1.QueueLen(); n != 1 {
+		t.Errorf("Expected c1 to receive the message, got %d", n)
+	}
+}
+
+func TestPublishToWildcardSubscribersOnce(t *testing.T) {
+	ch := NewChannel("/a/b")
+	one := NewChannel("/a/*")
+	all := NewChannel("/a/**")
+	s1 := newTestSession("c1")
+	s2 := newTestSession("c2")
+	ch.Subscribe(s1)
+	one.Subscribe(s1)
+	all.Subscribe(s2)
+	one.Subscribe(s2)
+
+	ch.Publish(&message.BayeuxMessage{Channel: "/a/b"}, one, all)
+	if n := s1.QueueLen(); n != 1 {
+		t.Errorf("Expected c1 to receive 1 message, got %d", n)
+	}
+	if n := s2.QueueLen(); n != 1 {
+		t.Errorf("Expected c2 to receive 1 message, got %d", n)
+	}
+}
+
+func TestPublishFiltered(t *testing.T) {
+	ch := NewChannel("/prices/aapl")
+	wild := NewChannel("/prices/*")
+	filter, err := selector.Parse(`symbol == "AAPL" && price > 100`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := selector.Parse(`symbol == "MSFT"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s1 := newTestSession("c1")
+	s2 := newTestSession("c2")
+	s3 := newTestSession("c3")
+	ch.SubscribeFiltered(s1, filter)
+	ch.SubscribeFiltered(s2, other)
+	wild.Subscribe(s2)
+	ch.SubscribeFiltered(s3, other)
+
+	ch.Publish(&message.BayeuxMessage{Channel: "/prices/aapl", Data: message.MustData(map[string]interface{}{"symbol": "AAPL", "price": 150})}, wild)
+	ch.Publish(&message.BayeuxMessage{Channel: "/prices/aapl", Data: message.MustData(map[string]interface{}{"symbol": "AAPL", "price": 90})}, wild)
+	if n := s1.QueueLen(); n != 1 {
+		t.Errorf("Expected c1 to receive 1 matching message, got %d", n)
+	}
+	if n := s2.QueueLen(); n != 2 {
+		t.Errorf("Expected c2 to receive both messages through its unfiltered wildcard subscription, got %d", n)
+	}
+	if n := s3.QueueLen(); n != 0 {
+		t.Errorf("Expected c3 to receive nothing, got %d", n)
+	}
+
+	// Subscribing again without a filter removes it.
+	ch.Subscribe(s3)
+	ch.Publish(&message.BayeuxMessage{Channel: "/prices/aapl", Data: message.MustData(1)})
+	if n := s3.QueueLen(); n != 1 {
+		t.Errorf("Expected c3 to receive 1 message after dropping its filter, got %d", n)
+	}
+}
+
+func TestPublishConflationKey(t *testing.T) {
+	plain := NewChannel("/chat")
+	prices := NewChannel("/prices")
+	prices.Conflate = true
+	s1 := newTestSession("c1")
+	plain.Subscribe(s1)
+	prices.Subscribe(s1)
+	for _, ch := range []*Channel{plain, prices} {
+		ch.Publish(&message.BayeuxMessage{Channel: ch.Name, ConflationKey: "k"})
+		ch.Publish(&message.BayeuxMessage{Channel: ch.Name, ConflationKey: "k"})
+	}
+	msgs := s1.DequeueAll()
+	if len(msgs) != 3 || msgs[0].Channel != "/chat" || msgs[1].Channel != "/chat" || msgs[2].Channel != "/prices" {
+		t.Errorf("Expected only the conflating channel to conflate, got %d messages", len(msgs))
+	}
+}
+
+func TestRetain(t *testing.T) {
+	ch := NewChannel("/status")
+	ch.Retain = true
+	s1 := newTestSession("c1")
+	ch.Subscribe(s1)
+	ch.Publish(&message.BayeuxMessage{Channel: "/status", Data: message.MustData(map[string]interface{}{"up": false})})
+	ch.Publish(&message.BayeuxMessage{Channel: "/status", Data: message.MustData(map[string]interface{}{"up": true})})
+	if n := s1.QueueLen(); n != 2 {
+		t.Errorf("Expected existing subscriber to receive 2 messages, got %d", n)
+	}
+
+	s2 := newTestSession("c2")
+	ch.Subscribe(s2)
+	if msgs := s2.DequeueAll(); len(msgs) != 1 || string(msgs[0].Data) != `{"up":true}` {
+		t.Errorf("Expected new subscriber to receive the last message, got %+v", msgs)
+	}
+	down, err := selector.Parse(`up == false`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s3 := newTestSession("c3")
+	ch.SubscribeFiltered(s3, down)
+	if n := s3.QueueLen(); n != 0 {
+		t.Errorf("Expected retained message not matching the filter to be withheld, got %d", n)
+	}
+
+	ch.Unsubscribe(s1)
+	ch.Unsubscribe(s2)
+	ch.Unsubscribe(s3)
+	if ch.Remove(time.Now().Add(time.Hour)) {
+		t.Errorf("Expected channel with a retained message not to be removed")
+	}
+	ch.SetRetained(nil)
+	if !ch.Remove(time.Now().Add(time.Hour)) {
+		t.Errorf("Expected channel to be removed once cleared")
+	}
+}
+
+func TestRemove(t *testing.T) {
+	now := time.Now()
+	ch := NewChannel("/idle")
+	ch.Touch(now)
+	if ch.Remove(now) {
+		t.Errorf("Expected channel active at the cutoff to be kept")
+	}
+	s := newTestSession("c1")
+	ch.Subscribe(s)
+	if ch.Remove(now.Add(time.Minute)) {
+		t.Errorf("Expected channel with subscribers to be kept")
+	}
+	ch.Unsubscribe(s)
+	ch.SetPersistent(true)
+	if ch.Remove(now.Add(time.Minute)) {
+		t.Errorf("Expected persistent channel to be kept")
+	}
+	ch.SetPersistent(false)
+	if !ch.Remove(now.Add(time.Minute)) || !ch.Removed() {
+		t.Fatalf("Expected idle channel to be removed")
+	}
+	if ch.Subscribe(s) {
+		t.Errorf("Expected removed channel to refuse subscribers")
+	}
+}
@@ -0,0 +1,101 @@
+package channel
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/charlinchui/galliard/message"
+)
+
+// Interceptor inspects, and may modify, a message about to be published to
+// a channel. A non-nil error rejects the message.
+type Interceptor func(ctx context.Context, msg *message.BayeuxMessage) error
+
+// SubscriptionListener is called after clientID subscribes to or
+// unsubscribes from a channel.
+type SubscriptionListener func(clientID string, subscribed bool)
+
+// hooks holds the per-channel behaviour configured by the server's users.
+// It has its own lock so that hooks never run under the channel lock.
+type hooks struct {
+	mu           sync.RWMutex
+	interceptors []Interceptor
+	listeners    []SubscriptionListener
+	attributes   map[string]interface{}
+}
+
+// AddInterceptor appends f to the interceptors run by Intercept.
+func (ch *Channel) AddInterceptor(f Interceptor) {
+	ch.hooks.mu.Lock()
+	defer ch.hooks.mu.Unlock()
+	ch.hooks.interceptors = append(ch.hooks.interceptors, f)
+}
+
+// Intercept runs the channel's interceptors on msg in the order they were
+// added, stopping at the first error.
+func (ch *Channel) Intercept(ctx context.Context, msg *message.BayeuxMessage) error {
+	ch.hooks.mu.RLock()
+	interceptors := ch.hooks.interceptors
+	ch.hooks.mu.RUnlock()
+	for _, f := range interceptors {
+		if err := f(ctx, msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AddSubscriptionListener appends f to the listeners notified by
+// Subscribe and Unsubscribe.
+func (ch *Channel) AddSubscriptionListener(f SubscriptionListener) {
+	ch.hooks.mu.Lock()
+	defer ch.hooks.mu.Unlock()
+	ch.hooks.listeners = append(ch.hooks.listeners, f)
+}
+
+func (ch *Channel) notifySubscription(clientID string, subscribed bool) {
+	ch.hooks.mu.RLock()
+	listeners := ch.hooks.listeners
+	ch.hooks.mu.RUnlock()
+	for _, f := range listeners {
+		f(clientID, subscribed)
+	}
+}
+
+// SetAttribute stores value under name.
+func (ch *Channel) SetAttribute(name string, value interface{}) {
+	ch.hooks.mu.Lock()
+	defer ch.hooks.mu.Unlock()
+	if ch.hooks.attributes == nil {
+		ch.hooks.attributes = make(map[string]interface{})
+	}
+	ch.hooks.attributes[name] = value
+}
+
+// Attribute returns the value stored under name.
+func (ch *Channel) Attribute(name string) (interface{}, bool) {
+	ch.hooks.mu.RLock()
+	defer ch.hooks.mu.RUnlock()
+	v, ok := ch.hooks.attributes[name]
+	return v, ok
+}
+
+// RemoveAttribute deletes the value stored under name.
+func (ch *Channel) RemoveAttribute(name string) {
+	ch.hooks.mu.Lock()
+	defer ch.hooks.mu.Unlock()
+	delete(ch.hooks.attributes, name)
+}
+
+// AttributeNames returns the names of the stored attributes in sorted order.
+func (ch *Channel) AttributeNames() []string {
+	ch.hooks.mu.RLock()
+	defer ch.hooks.mu.RUnlock()
+	names := make([]string, 0, len(ch.hooks.attributes))
+	for name := range ch.hooks.attributes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
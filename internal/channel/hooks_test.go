@@ -0,0 +1,85 @@
+package channel
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/charlinchui/galliard/message"
+)
+
+func TestIntercept(t *testing.T) {
+	ch := NewChannel("/chat")
+	var order []int
+	ch.AddInterceptor(func(_ context.Context, msg *message.BayeuxMessage) error {
+		order = append(order, 1)
+		msg.Data = message.MustData("rewritten")
+		return nil
+	})
+	errRejected := errors.New("rejected")
+	ch.AddInterceptor(func(_ context.Context, msg *message.BayeuxMessage) error {
+		order = append(order, 2)
+		if msg.ID == "bad" {
+			return errRejected
+		}
+		return nil
+	})
+	ch.AddInterceptor(func(context.Context, *message.BayeuxMessage) error {
+		order = append(order, 3)
+		return nil
+	})
+
+	msg := &message.BayeuxMessage{Channel: "/chat", Data: message.MustData("original")}
+	if err := ch.Intercept(context.Background(), msg); err != nil {
+		t.Fatalf("Intercept failed: %v", err)
+	}
+	if string(msg.Data) != `"rewritten"` {
+		t.Errorf("Expected interceptor to modify data, got %s", msg.Data)
+	}
+	order = nil
+	if err := ch.Intercept(context.Background(), &message.BayeuxMessage{ID: "bad"}); err != errRejected {
+		t.Errorf("Expected rejection, got %v", err)
+	}
+	if len(order) != 2 {
+		t.Errorf("Expected interceptors to stop at the first error, ran %v", order)
+	}
+}
+
+func TestSubscriptionListener(t *testing.T) {
+	ch := NewChannel("/room")
+	var events []string
+	ch.AddSubscriptionListener(func(clientID string, subscribed bool) {
+		if subscribed {
+			events = append(events, "+"+clientID)
+		} else {
+			events = append(events, "-"+clientID)
+		}
+	})
+	s := newTestSession("c1")
+	ch.Subscribe(s)
+	ch.Subscribe(s)
+	ch.Unsubscribe(s)
+	ch.Unsubscribe(s)
+	if len(events) != 2 || events[0] != "+c1" || events[1] != "-c1" {
+		t.Errorf("Expected one subscribe and one unsubscribe event, got %v", events)
+	}
+}
+
+func TestAttributes(t *testing.T) {
+	ch := NewChannel("/room")
+	if _, ok := ch.Attribute("topic"); ok {
+		t.Errorf("Expected no attribute on a new channel")
+	}
+	ch.SetAttribute("topic", "Go")
+	ch.SetAttribute("owner", 42)
+	if v, ok := ch.Attribute("topic"); !ok || v != "Go" {
+		t.Errorf("Expected topic Go, got %v", v)
+	}
+	if names := ch.AttributeNames(); len(names) != 2 || names[0] != "owner" || names[1] != "topic" {
+		t.Errorf("Unexpected attribute names %v", names)
+	}
+	ch.RemoveAttribute("owner")
+	if _, ok := ch.Attribute("owner"); ok {
+		t.Errorf("Expected owner to be removed")
+	}
+}
@@ -0,0 +1,98 @@
+package channel
+
+import "strings"
+
+// Match reports whether the channel name matches pattern.
+// Besides exact names, a pattern ending in "/*" matches exactly one further
+// segment and a pattern ending in "/**" matches one or more further segments,
+// following Bayeux wildcard rules.
+func Match(pattern, name string) bool {
+	if pattern == name {
+		return true
+	}
+	if prefix, ok := strings.CutSuffix(pattern, "/**"); ok {
+		rest, ok := strings.CutPrefix(name, prefix+"/")
+		return ok && rest != ""
+	}
+	if prefix, ok := strings.CutSuffix(pattern, "/*"); ok {
+		rest, ok := strings.CutPrefix(name, prefix+"/")
+		return ok && rest != "" && !strings.Contains(rest, "/")
+	}
+	return false
+}
+
+// Valid reports whether name is a well-formed channel name or wildcard
+// pattern: it starts with "/", has no empty segments, and uses "*" or "**"
+// only as its last segment.
+func Valid(name string) bool {
+	if !strings.HasPrefix(name, "/") {
+		return false
+	}
+	segments := strings.Split(name[1:], "/")
+	for i, seg := range segments {
+		if seg == "" {
+			return false
+		}
+		if strings.Contains(seg, "*") && (i != len(segments)-1 || (seg != "*" && seg != "**")) {
+			return false
+		}
+	}
+	return true
+}
+
+// IsWildcard reports whether name is a wildcard pattern.
+func IsWildcard(name string) bool {
+	return strings.HasSuffix(name, "/*") || strings.HasSuffix(name, "/**")
+}
+
+// Intersection returns the channel name or wildcard pattern matching
+// exactly the channel names matched by both a and b, each a channel name or
+// wildcard pattern. It reports false if no channel name matches both.
+func Intersection(a, b string) (string, bool) {
+	if !IsWildcard(a) {
+		return a, Match(b, a)
+	}
+	if !IsWildcard(b) {
+		return b, Match(a, b)
+	}
+	prefixA, kindA := splitWildcard(a)
+	prefixB, kindB := splitWildcard(b)
+	switch {
+	case prefixA == prefixB:
+		if kindA == "*" {
+			return a, true
+		}
+		return b, true
+	case strings.HasPrefix(prefixB, prefixA+"/"):
+		// Names under b are at least two segments below prefixA, which
+		// only a "/**" pattern reaches.
+		return b, kindA == "**"
+	case strings.HasPrefix(prefixA, prefixB+"/"):
+		return a, kindB == "**"
+	}
+	return "", false
+}
+
+// splitWildcard splits a wildcard pattern into its prefix and "*" or "**".
+func splitWildcard(pattern string) (prefix, kind string) {
+	i := strings.LastIndex(pattern, "/")
+	return pattern[:i], pattern[i+1:]
+}
+
+// Wildcards returns the wildcard patterns matching the channel name, from
+// most to least specific. For "/a/b" they are "/a/*", "/a/**" and "/**".
+func Wildcards(name string) []string {
+	var patterns []string
+	prefix := name
+	for {
+		i := strings.LastIndex(prefix, "/")
+		if i < 0 {
+			return patterns
+		}
+		prefix = prefix[:i]
+		if len(patterns) == 0 {
+			patterns = append(patterns, prefix+"/*")
+		}
+		patterns = append(patterns, prefix+"/**")
+	}
+}
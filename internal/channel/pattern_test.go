@@ -0,0 +1,105 @@
+package channel
+
+import "testing"
+
+func TestMatch(t *testing.T) {
+	cases := []struct {
+		pattern, name string
+		want          bool
+	}{
+		{"/foo", "/foo", true},
+		{"/foo", "/foo/bar", false},
+		{"/foo/*", "/foo/bar", true},
+		{"/foo/*", "/foo/bar/baz", false},
+		{"/foo/*", "/foo", false},
+		{"/foo/**", "/foo/bar", true},
+		{"/foo/**", "/foo/bar/baz", true},
+		{"/foo/**", "/foo", false},
+		{"/foo/**", "/foobar/baz", false},
+		{"/**", "/anything/at/all", true},
+		{"/*", "/top", true},
+		{"/*", "/top/level", false},
+	}
+	for _, c := range cases {
+		if got := Match(c.pattern, c.name); got != c.want {
+			t.Errorf("Match(%q, %q) = %v, want %v", c.pattern, c.name, got, c.want)
+		}
+	}
+}
+
+func TestValid(t *testing.T) {
+	cases := map[string]bool{
+		"/foo":        true,
+		"/foo/bar":    true,
+		"/foo/*":      true,
+		"/foo/**":     true,
+		"/**":         true,
+		"foo":         false,
+		"":            false,
+		"/":           false,
+		"/foo//bar":   false,
+		"/foo/":       false,
+		"/foo/*/bar":  false,
+		"/foo/b*":     false,
+		"/foo/***":    false,
+		"/foo/**/bar": false,
+	}
+	for name, want := range cases {
+		if got := Valid(name); got != want {
+			t.Errorf("Valid(%q) = %v, want %v", name, got, want)
+		}
+	}
+	if !IsWildcard("/foo/*") || !IsWildcard("/foo/**") || IsWildcard("/foo") {
+		t.Errorf("IsWildcard misclassified a channel")
+	}
+}
+
+func TestWildcards(t *testing.T) {
+	got := Wildcards("/a/b/c")
+	want := []string{"/a/b/*", "/a/b/**", "/a/**", "/**"}
+	if len(got) != len(want) {
+		t.Fatalf("Wildcards = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("Wildcards = %v, want %v", got, want)
+		}
+		if !Match(got[i], "/a/b/c") {
+			t.Errorf("Expected %q to match /a/b/c", got[i])
+		}
+	}
+	if got := Wildcards("/top"); len(got) != 2 || got[0] != "/*" || got[1] != "/**" {
+		t.Errorf("Wildcards(/top) = %v", got)
+	}
+}
+
+func TestIntersection(t *testing.T) {
+	cases := []struct {
+		a, b string
+		want string
+	}{
+		{"/a/b", "/a/b", "/a/b"},
+		{"/a/b", "/a/c", ""},
+		{"/a/*", "/a/b", "/a/b"},
+		{"/a/b", "/**", "/a/b"},
+		{"/**", "/internal/**", "/internal/**"},
+		{"/*", "/internal/**", ""},
+		{"/*", "/internal/*", ""},
+		{"/internal/*", "/internal/**", "/internal/*"},
+		{"/internal/**", "/internal/a/*", "/internal/a/*"},
+		{"/internal/*", "/internal/a/*", ""},
+		{"/internal/**", "/public/**", ""},
+		{"/inter/**", "/internal/*", ""},
+	}
+	for _, c := range cases {
+		for _, args := range [][2]string{{c.a, c.b}, {c.b, c.a}} {
+			got, ok := Intersection(args[0], args[1])
+			if !ok {
+				got = ""
+			}
+			if got != c.want {
+				t.Errorf("Intersection(%q, %q) = %q, want %q", args[0], args[1], got, c.want)
+			}
+		}
+	}
+}
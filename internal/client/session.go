@@ -1,17 +1,65 @@
 package client
 
 import (
+	"sort"
 	"sync"
+	"time"
 
 	"github.com/charlinchui/galliard/message"
 )
 
+// Principal is the authenticated identity behind a session.
+type Principal struct {
+	// Subject identifies the user, e.g. the "sub" claim of a token.
+	Subject string
+	// Claims holds any further attributes established by authentication.
+	Claims map[string]interface{}
+}
+
 type Session struct {
 	ID            string
 	Subscriptions map[string]struct{}
 	MessageQueue  []*message.BayeuxMessage
 	Advice        *message.Advice
-	mu            sync.Mutex
+	// MaxQueue bounds MessageQueue; when full, the oldest message is dropped.
+	// Zero means unbounded. It must be set before the session is shared.
+	MaxQueue int
+	// OnDrop, if set, is called with each message dropped from a full queue.
+	// It runs with the session locked and must not call back into it.
+	OnDrop func(*message.BayeuxMessage)
+	// OnExpire, if set, is called with each message discarded because its
+	// Expires time passed while it was queued. Like OnDrop, it runs with the
+	// session locked.
+	OnExpire func(*message.BayeuxMessage)
+	// Now, if set, supplies the time messages are checked for expiry
+	// against; it defaults to time.Now. It must be set before the session
+	// is shared.
+	Now func() time.Time
+	// MaxLazy is how long a lazy message may wait before waking the client.
+	// It must be set before the session is shared.
+	MaxLazy   time.Duration
+	lazyTimer *time.Timer
+	// due is set once the queue holds a message that should be delivered
+	// now: a non-lazy one, or lazy ones that have waited MaxLazy.
+	due bool
+	// conflated maps the channel and conflation key of queued messages to
+	// their position in the queue, counted from the start of the session
+	// so that dropping from the front does not move them. head is the
+	// position of MessageQueue[0].
+	conflated map[conflationKey]int
+	head      int
+	connected bool
+	lastSeen  time.Time
+	principal *Principal
+	transport string
+	wake      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+	mu        sync.Mutex
+}
+
+type conflationKey struct {
+	channel, key string
 }
 
 func (s *Session) SetAdvice(advice *message.Advice) {
@@ -31,6 +79,8 @@ func NewSession(id string) *Session {
 		ID:            id,
 		Subscriptions: make(map[string]struct{}),
 		MessageQueue:  []*message.BayeuxMessage{},
+		wake:          make(chan struct{}, 1),
+		done:          make(chan struct{}),
 	}
 }
 
@@ -53,16 +103,263 @@ func (s *Session) IsSubscribed(channel string) bool {
 	return ok
 }
 
+// Enqueue queues msg and wakes the client, delivering it together with any
+// lazy messages queued before it.
 func (s *Session) Enqueue(msg *message.BayeuxMessage) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	s.push(msg)
+	s.stopLazyTimer()
+	s.due = true
+	s.signal()
+}
+
+// EnqueueLazy queues msg without waking the client. The client is woken
+// when a non-lazy message arrives or after MaxLazy, whichever comes first,
+// so that bursts of lazy messages are delivered together.
+func (s *Session) EnqueueLazy(msg *message.BayeuxMessage) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.push(msg)
+	if s.MaxLazy <= 0 {
+		s.due = true
+		s.signal()
+		return
+	}
+	if s.lazyTimer == nil {
+		s.lazyTimer = time.AfterFunc(s.MaxLazy, s.lazyExpired)
+	}
+}
+
+func (s *Session) lazyExpired() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lazyTimer = nil
+	if len(s.MessageQueue) > 0 {
+		s.due = true
+		s.signal()
+	}
+}
+
+// push queues msg, or, if a message with the same channel and conflation
+// key is already queued, replaces that message with it.
+func (s *Session) push(msg *message.BayeuxMessage) {
+	if msg.ConflationKey != "" {
+		key := conflationKey{msg.Channel, msg.ConflationKey}
+		if pos, ok := s.conflated[key]; ok && pos >= s.head {
+			s.MessageQueue[pos-s.head] = msg
+			return
+		}
+		if s.conflated == nil {
+			s.conflated = make(map[conflationKey]int)
+		}
+		s.conflated[key] = s.head + len(s.MessageQueue)
+	}
 	s.MessageQueue = append(s.MessageQueue, msg)
+	if s.MaxQueue > 0 && len(s.MessageQueue) > s.MaxQueue {
+		dropped := s.MessageQueue[0]
+		s.MessageQueue = s.MessageQueue[1:]
+		s.head++
+		if s.OnDrop != nil {
+			s.OnDrop(dropped)
+		}
+	}
+}
+
+// reindex rebuilds the positions of conflatable messages after the queue
+// was rearranged.
+func (s *Session) reindex() {
+	s.conflated = nil
+	s.head = 0
+	for i, msg := range s.MessageQueue {
+		if msg.ConflationKey != "" {
+			if s.conflated == nil {
+				s.conflated = make(map[conflationKey]int)
+			}
+			s.conflated[conflationKey{msg.Channel, msg.ConflationKey}] = i
+		}
+	}
 }
 
+func (s *Session) signal() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (s *Session) stopLazyTimer() {
+	if s.lazyTimer != nil {
+		s.lazyTimer.Stop()
+		s.lazyTimer = nil
+	}
+}
+
+// DequeueAll removes and returns the queued messages, discarding those that
+// have expired.
 func (s *Session) DequeueAll() []*message.BayeuxMessage {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	s.dropExpired()
 	msgs := s.MessageQueue
 	s.MessageQueue = []*message.BayeuxMessage{}
+	s.conflated = nil
+	s.head = 0
+	s.due = false
+	s.stopLazyTimer()
 	return msgs
 }
+
+// DropExpired discards queued messages that have expired, returning how
+// many it discarded.
+func (s *Session) DropExpired() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.dropExpired()
+}
+
+func (s *Session) dropExpired() int {
+	var now time.Time
+	kept := s.MessageQueue[:0]
+	for _, msg := range s.MessageQueue {
+		if !msg.Expires.IsZero() {
+			if now.IsZero() {
+				now = s.now()
+			}
+			if !now.Before(msg.Expires) {
+				if s.OnExpire != nil {
+					s.OnExpire(msg)
+				}
+				continue
+			}
+		}
+		kept = append(kept, msg)
+	}
+	dropped := len(s.MessageQueue) - len(kept)
+	clear(s.MessageQueue[len(kept):])
+	s.MessageQueue = kept
+	if dropped > 0 {
+		s.reindex()
+	}
+	return dropped
+}
+
+func (s *Session) now() time.Time {
+	if s.Now != nil {
+		return s.Now()
+	}
+	return time.Now()
+}
+
+// Requeue puts msgs back at the front of the queue, ahead of any messages
+// queued since they were dequeued.
+func (s *Session) Requeue(msgs []*message.BayeuxMessage) {
+	if len(msgs) == 0 {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.MessageQueue = append(append([]*message.BayeuxMessage{}, msgs...), s.MessageQueue...)
+	s.reindex()
+	s.due = true
+}
+
+// Due reports whether queued messages should be delivered now: a non-lazy
+// message is queued, or lazy messages have waited MaxLazy. A connect that
+// finds only lazy messages waiting should be held until Due.
+func (s *Session) Due() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.due && len(s.MessageQueue) > 0
+}
+
+// MarkConnected records that the session has sent a /meta/connect and
+// reports whether this was its first one.
+func (s *Session) MarkConnected() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	first := !s.connected
+	s.connected = true
+	return first
+}
+
+// Wake returns a channel that receives a value after a message is enqueued.
+// Only one pending wake-up is buffered, so waiters should drain the queue
+// with DequeueAll once woken.
+func (s *Session) Wake() <-chan struct{} {
+	return s.wake
+}
+
+// Close marks the session as finished, releasing any goroutine waiting on Done.
+func (s *Session) Close() {
+	s.closeOnce.Do(func() { close(s.done) })
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stopLazyTimer()
+}
+
+// Done returns a channel that is closed when the session is closed.
+func (s *Session) Done() <-chan struct{} {
+	return s.done
+}
+
+// Touch records t as the last time the client was heard from.
+func (s *Session) Touch(t time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastSeen = t
+}
+
+// LastSeen returns the last time the client was heard from.
+func (s *Session) LastSeen() time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lastSeen
+}
+
+// SetTransport records the connection type the client is using.
+func (s *Session) SetTransport(transport string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.transport = transport
+}
+
+// Transport returns the connection type the client is using.
+func (s *Session) Transport() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.transport
+}
+
+// QueueLen returns the number of messages waiting to be delivered.
+func (s *Session) QueueLen() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.MessageQueue)
+}
+
+// SubscriptionList returns the subscribed channel names in sorted order.
+func (s *Session) SubscriptionList() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	subs := make([]string, 0, len(s.Subscriptions))
+	for sub := range s.Subscriptions {
+		subs = append(subs, sub)
+	}
+	sort.Strings(subs)
+	return subs
+}
+
+// SetPrincipal attaches the authenticated identity to the session.
+func (s *Session) SetPrincipal(p *Principal) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.principal = p
+}
+
+// Principal returns the authenticated identity, or nil if there is none.
+func (s *Session) Principal() *Principal {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.principal
+}
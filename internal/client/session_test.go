@@ -1,7 +1,9 @@
 package client
 
 import (
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/charlinchui/galliard/message"
 )
@@ -65,3 +67,173 @@ func TestSessionAdvice(t *testing.T) {
 		t.Errorf("Advice fields not set correctly: %+v", got)
 	}
 }
+
+func TestMarkConnected(t *testing.T) {
+	s := NewSession("client-4")
+	if !s.MarkConnected() {
+		t.Errorf("Expected first connect to be reported")
+	}
+	if s.MarkConnected() {
+		t.Errorf("Expected later connects not to be reported as first")
+	}
+}
+
+func TestWakeAndClose(t *testing.T) {
+	s := NewSession("client-5")
+	select {
+	case <-s.Wake():
+		t.Fatalf("Expected no wake-up before enqueue")
+	default:
+	}
+	s.Enqueue(&message.BayeuxMessage{Channel: "/foo"})
+	s.Enqueue(&message.BayeuxMessage{Channel: "/foo"})
+	select {
+	case <-s.Wake():
+	default:
+		t.Fatalf("Expected wake-up after enqueue")
+	}
+
+	s.Close()
+	s.Close()
+	select {
+	case <-s.Done():
+	default:
+		t.Errorf("Expected Done to be closed")
+	}
+}
+
+func TestMaxQueueDropsOldest(t *testing.T) {
+	s := NewSession("client-6")
+	s.MaxQueue = 2
+	var dropped []string
+	s.OnDrop = func(msg *message.BayeuxMessage) { dropped = append(dropped, msg.ID) }
+	for _, id := range []string{"1", "2", "3"} {
+		s.Enqueue(&message.BayeuxMessage{Channel: "/foo", ID: id})
+	}
+	msgs := s.DequeueAll()
+	if len(msgs) != 2 || msgs[0].ID != "2" || msgs[1].ID != "3" {
+		t.Errorf("Expected the two newest messages, got %+v", msgs)
+	}
+	if len(dropped) != 1 || dropped[0] != "1" {
+		t.Errorf("Expected message 1 to be dropped, got %v", dropped)
+	}
+}
+
+func TestEnqueueLazy(t *testing.T) {
+	s := NewSession("client-7")
+	s.MaxLazy = 30 * time.Millisecond
+	s.EnqueueLazy(&message.BayeuxMessage{Channel: "/ticks", ID: "1"})
+	s.EnqueueLazy(&message.BayeuxMessage{Channel: "/ticks", ID: "2"})
+	select {
+	case <-s.Wake():
+		t.Fatalf("Expected lazy messages not to wake the client immediately")
+	default:
+	}
+	select {
+	case <-s.Wake():
+	case <-time.After(time.Second):
+		t.Fatalf("Expected lazy messages to wake the client after MaxLazy")
+	}
+	if msgs := s.DequeueAll(); len(msgs) != 2 {
+		t.Errorf("Expected 2 lazy messages, got %d", len(msgs))
+	}
+}
+
+func TestNonLazyFlushesLazy(t *testing.T) {
+	s := NewSession("client-8")
+	s.MaxLazy = time.Hour
+	s.EnqueueLazy(&message.BayeuxMessage{Channel: "/ticks", ID: "1"})
+	s.Enqueue(&message.BayeuxMessage{Channel: "/alerts", ID: "2"})
+	select {
+	case <-s.Wake():
+	default:
+		t.Fatalf("Expected non-lazy message to wake the client")
+	}
+	msgs := s.DequeueAll()
+	if len(msgs) != 2 || msgs[0].ID != "1" || msgs[1].ID != "2" {
+		t.Errorf("Expected lazy and non-lazy messages in order, got %+v", msgs)
+	}
+	s.Close()
+}
+
+func TestRequeue(t *testing.T) {
+	s := NewSession("client-9")
+	s.Enqueue(&message.BayeuxMessage{ID: "3"})
+	s.Requeue([]*message.BayeuxMessage{{ID: "1"}, {ID: "2"}})
+	msgs := s.DequeueAll()
+	if len(msgs) != 3 || msgs[0].ID != "1" || msgs[1].ID != "2" || msgs[2].ID != "3" {
+		t.Errorf("Expected requeued messages first, got %+v", msgs)
+	}
+}
+
+func TestDropExpired(t *testing.T) {
+	now := time.Unix(100, 0)
+	s := NewSession("client-10")
+	s.Now = func() time.Time { return now }
+	var expired []string
+	s.OnExpire = func(msg *message.BayeuxMessage) { expired = append(expired, msg.ID) }
+	s.Enqueue(&message.BayeuxMessage{ID: "1", Expires: now.Add(time.Second)})
+	s.Enqueue(&message.BayeuxMessage{ID: "2"})
+	s.Enqueue(&message.BayeuxMessage{ID: "3", Expires: now.Add(time.Minute)})
+
+	if n := s.DropExpired(); n != 0 {
+		t.Errorf("Expected nothing expired yet, got %d", n)
+	}
+	now = now.Add(time.Second)
+	if n := s.DropExpired(); n != 1 || len(expired) != 1 || expired[0] != "1" {
+		t.Errorf("Expected message 1 to expire, got %d %v", n, expired)
+	}
+	now = now.Add(time.Minute)
+	msgs := s.DequeueAll()
+	if len(msgs) != 1 || msgs[0].ID != "2" {
+		t.Errorf("Expected only the message without expiry, got %+v", msgs)
+	}
+	if len(expired) != 2 {
+		t.Errorf("Expected message 3 to expire at dequeue, got %v", expired)
+	}
+}
+
+func TestConflation(t *testing.T) {
+	s := NewSession("client-11")
+	s.MaxQueue = 4
+	s.Enqueue(&message.BayeuxMessage{ID: "0"})
+	s.Enqueue(&message.BayeuxMessage{ID: "1", Channel: "/prices", ConflationKey: "AAPL"})
+	s.Enqueue(&message.BayeuxMessage{ID: "2", Channel: "/prices", ConflationKey: "MSFT"})
+	s.Enqueue(&message.BayeuxMessage{ID: "3", Channel: "/prices", ConflationKey: "AAPL"})
+	s.Enqueue(&message.BayeuxMessage{ID: "4", Channel: "/trades", ConflationKey: "AAPL"})
+	s.Enqueue(&message.BayeuxMessage{ID: "5"})
+	// The queue is full, so "0" is dropped, without disturbing conflation.
+	s.Enqueue(&message.BayeuxMessage{ID: "6", Channel: "/prices", ConflationKey: "MSFT"})
+
+	msgs := s.DequeueAll()
+	var ids []string
+	for _, msg := range msgs {
+		ids = append(ids, msg.ID)
+	}
+	if got := strings.Join(ids, ","); got != "3,6,4,5" {
+		t.Errorf("Expected newest message per key in order of first appearance, got %s", got)
+	}
+
+	s.Requeue(msgs[:1])
+	s.Enqueue(&message.BayeuxMessage{ID: "7", Channel: "/prices", ConflationKey: "AAPL"})
+	if msgs := s.DequeueAll(); len(msgs) != 1 || msgs[0].ID != "7" {
+		t.Errorf("Expected requeued message to be conflated, got %+v", msgs)
+	}
+}
+
+func TestDue(t *testing.T) {
+	s := NewSession("client-12")
+	s.MaxLazy = time.Hour
+	s.EnqueueLazy(&message.BayeuxMessage{ID: "1"})
+	if s.Due() {
+		t.Errorf("Expected lazy message not to be due")
+	}
+	s.Enqueue(&message.BayeuxMessage{ID: "2"})
+	if !s.Due() {
+		t.Errorf("Expected non-lazy message to be due")
+	}
+	s.DequeueAll()
+	if s.Due() {
+		t.Errorf("Expected empty queue not to be due")
+	}
+}
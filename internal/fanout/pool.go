@@ -0,0 +1,79 @@
+// Package fanout runs message delivery on a bounded pool of workers, off the
+// goroutine that published the message.
+package fanout
+
+import (
+	"hash/fnv"
+	"sync"
+	"sync/atomic"
+)
+
+// Pool is a fixed set of workers, each with a bounded queue of jobs.
+// Jobs are assigned to workers by key, so jobs sharing a key run one at a
+// time in the order they were submitted.
+type Pool struct {
+	queues  []chan func()
+	pending atomic.Int64
+	wg      sync.WaitGroup
+	mu      sync.RWMutex
+	closed  bool
+}
+
+// NewPool starts workers goroutines, each queueing up to queueSize jobs.
+func NewPool(workers, queueSize int) *Pool {
+	p := &Pool{queues: make([]chan func(), workers)}
+	for i := range p.queues {
+		q := make(chan func(), queueSize)
+		p.queues[i] = q
+		p.wg.Add(1)
+		go p.work(q)
+	}
+	return p
+}
+
+func (p *Pool) work(q chan func()) {
+	defer p.wg.Done()
+	for job := range q {
+		job()
+		p.pending.Add(-1)
+	}
+}
+
+// Submit queues job on the worker owning key, blocking while that worker's
+// queue is full. Once the pool is closed, job runs on the caller's goroutine.
+func (p *Pool) Submit(key string, job func()) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.closed {
+		job()
+		return
+	}
+	p.pending.Add(1)
+	p.queues[p.worker(key)] <- job
+}
+
+func (p *Pool) worker(key string) int {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return int(h.Sum32() % uint32(len(p.queues)))
+}
+
+// Len returns the number of jobs queued or running.
+func (p *Pool) Len() int {
+	return int(p.pending.Load())
+}
+
+// Close runs the jobs already queued and stops the workers.
+// Jobs submitted afterwards run synchronously.
+func (p *Pool) Close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return
+	}
+	p.closed = true
+	for _, q := range p.queues {
+		close(q)
+	}
+	p.wg.Wait()
+}
@@ -0,0 +1,61 @@
+package fanout
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestSubmitKeepsOrderPerKey(t *testing.T) {
+	p := NewPool(4, 8)
+	var mu sync.Mutex
+	got := map[string][]int{}
+	for i := 0; i < 100; i++ {
+		for _, key := range []string{"/a", "/b", "/c"} {
+			key, i := key, i
+			p.Submit(key, func() {
+				mu.Lock()
+				got[key] = append(got[key], i)
+				mu.Unlock()
+			})
+		}
+	}
+	p.Close()
+	for key, seq := range got {
+		if len(seq) != 100 {
+			t.Fatalf("Expected 100 jobs for %s, got %d", key, len(seq))
+		}
+		for i, v := range seq {
+			if v != i {
+				t.Fatalf("Jobs for %s ran out of order: %v", key, seq)
+			}
+		}
+	}
+}
+
+func TestLenCountsPendingJobs(t *testing.T) {
+	p := NewPool(1, 4)
+	release := make(chan struct{})
+	started := make(chan struct{})
+	p.Submit("/a", func() { close(started); <-release })
+	<-started
+	p.Submit("/a", func() {})
+	if n := p.Len(); n != 2 {
+		t.Errorf("Expected 2 pending jobs, got %d", n)
+	}
+	close(release)
+	p.Close()
+	if n := p.Len(); n != 0 {
+		t.Errorf("Expected no pending jobs after Close, got %d", n)
+	}
+}
+
+func TestSubmitAfterCloseRunsInline(t *testing.T) {
+	p := NewPool(2, 1)
+	p.Close()
+	ran := false
+	p.Submit("/a", func() { ran = true })
+	if !ran {
+		t.Error("Expected job submitted after Close to run synchronously")
+	}
+	p.Close()
+}
@@ -0,0 +1,79 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// Bucket is a token bucket refilled at a constant rate up to its burst size.
+type Bucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+	mu     sync.Mutex
+}
+
+// NewBucket returns a full bucket that refills rate tokens per second and
+// holds at most burst tokens.
+func NewBucket(rate float64, burst int, now time.Time) *Bucket {
+	return &Bucket{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   now,
+	}
+}
+
+// Allow takes one token if available at time now and reports whether it did.
+func (b *Bucket) Allow(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill(now)
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// AllowAll takes one token from each of buckets if all of them have one
+// available at time now. Otherwise it takes none and returns the index of
+// the first bucket without a token; it returns -1 if the tokens were taken.
+// The buckets are locked together, so callers must always pass them in a
+// consistent order.
+func AllowAll(now time.Time, buckets ...*Bucket) int {
+	for _, b := range buckets {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+	}
+	for i, b := range buckets {
+		b.refill(now)
+		if b.tokens < 1 {
+			return i
+		}
+	}
+	for _, b := range buckets {
+		b.tokens--
+	}
+	return -1
+}
+
+// Full reports whether the bucket would be full at time now, in which case
+// it is indistinguishable from a new bucket and may be discarded.
+func (b *Bucket) Full(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill(now)
+	return b.tokens >= b.burst
+}
+
+func (b *Bucket) refill(now time.Time) {
+	if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens += elapsed * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+		b.last = now
+	}
+}
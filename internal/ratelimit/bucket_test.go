@@ -0,0 +1,49 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBucketBurstAndRefill(t *testing.T) {
+	now := time.Unix(0, 0)
+	b := NewBucket(2, 3, now)
+	for i := 0; i < 3; i++ {
+		if !b.Allow(now) {
+			t.Fatalf("Expected burst token %d to be allowed", i)
+		}
+	}
+	if b.Allow(now) {
+		t.Errorf("Expected empty bucket to deny")
+	}
+	now = now.Add(500 * time.Millisecond)
+	if !b.Allow(now) {
+		t.Errorf("Expected one token after 500ms at 2/s")
+	}
+	if b.Allow(now) {
+		t.Errorf("Expected bucket to be empty again")
+	}
+	if b.Full(now.Add(time.Second)) {
+		t.Errorf("Expected bucket not to be full after 1s")
+	}
+	if !b.Full(now.Add(2 * time.Second)) {
+		t.Errorf("Expected bucket to be full after 2s")
+	}
+}
+
+func TestAllowAllTakesNothingUnlessAllAllow(t *testing.T) {
+	now := time.Unix(0, 0)
+	wide, narrow := NewBucket(1, 2, now), NewBucket(1, 1, now)
+	if i := AllowAll(now, wide, narrow); i != -1 {
+		t.Fatalf("Expected both buckets to allow, got index %d", i)
+	}
+	if i := AllowAll(now, wide, narrow); i != 1 {
+		t.Fatalf("Expected the second bucket to deny, got index %d", i)
+	}
+	if !wide.Allow(now) {
+		t.Errorf("Expected a denied AllowAll to leave the first bucket's token")
+	}
+	if i := AllowAll(now, wide); i != 0 {
+		t.Errorf("Expected an empty bucket to deny, got index %d", i)
+	}
+}
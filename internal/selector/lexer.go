@@ -0,0 +1,139 @@
+package selector
+
+import (
+	"fmt"
+	"strings"
+)
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokError
+	tokIdent
+	tokString
+	tokNumber
+	tokOp
+	tokIn
+	tokAnd
+	tokOr
+	tokNot
+	tokLParen
+	tokRParen
+	tokComma
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+func (t token) String() string {
+	switch t.kind {
+	case tokEOF:
+		return "end of expression"
+	case tokError:
+		return t.text
+	case tokString:
+		return fmt.Sprintf("string %q", t.text)
+	}
+	return fmt.Sprintf("%q", t.text)
+}
+
+type lexer struct {
+	src string
+	pos int
+}
+
+func (l *lexer) next() token {
+	for l.pos < len(l.src) && strings.IndexByte(" \t\r\n", l.src[l.pos]) >= 0 {
+		l.pos++
+	}
+	start := l.pos
+	if l.pos >= len(l.src) {
+		return token{kind: tokEOF, pos: start}
+	}
+	c := l.src[l.pos]
+	rest := l.src[l.pos:]
+	for _, op := range []string{"==", "!=", "<=", ">=", "&&", "||"} {
+		if strings.HasPrefix(rest, op) {
+			l.pos += 2
+			switch op {
+			case "&&":
+				return token{kind: tokAnd, text: op, pos: start}
+			case "||":
+				return token{kind: tokOr, text: op, pos: start}
+			}
+			return token{kind: tokOp, text: op, pos: start}
+		}
+	}
+	switch {
+	case c == '<' || c == '>':
+		l.pos++
+		return token{kind: tokOp, text: string(c), pos: start}
+	case c == '!':
+		l.pos++
+		return token{kind: tokNot, text: "!", pos: start}
+	case c == '(':
+		l.pos++
+		return token{kind: tokLParen, text: "(", pos: start}
+	case c == ')':
+		l.pos++
+		return token{kind: tokRParen, text: ")", pos: start}
+	case c == ',':
+		l.pos++
+		return token{kind: tokComma, text: ",", pos: start}
+	case c == '"' || c == '\'':
+		return l.lexString(c)
+	case c == '-' || isDigit(c):
+		l.pos++
+		for l.pos < len(l.src) && (isDigit(l.src[l.pos]) || strings.IndexByte(".eE+-", l.src[l.pos]) >= 0) {
+			l.pos++
+		}
+		return token{kind: tokNumber, text: l.src[start:l.pos], pos: start}
+	case isIdentStart(c):
+		for l.pos < len(l.src) && (isIdentStart(l.src[l.pos]) || isDigit(l.src[l.pos]) || l.src[l.pos] == '.') {
+			l.pos++
+		}
+		text := l.src[start:l.pos]
+		if text == "in" {
+			return token{kind: tokIn, text: text, pos: start}
+		}
+		return token{kind: tokIdent, text: text, pos: start}
+	}
+	l.pos = len(l.src)
+	return token{kind: tokError, text: fmt.Sprintf("unexpected character %q", c), pos: start}
+}
+
+// lexString reads a string quoted with quote, in which a backslash escapes
+// the following character.
+func (l *lexer) lexString(quote byte) token {
+	start := l.pos
+	l.pos++
+	var b strings.Builder
+	for l.pos < len(l.src) {
+		c := l.src[l.pos]
+		l.pos++
+		switch c {
+		case quote:
+			return token{kind: tokString, text: b.String(), pos: start}
+		case '\\':
+			if l.pos < len(l.src) {
+				b.WriteByte(l.src[l.pos])
+				l.pos++
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return token{kind: tokError, text: "unterminated string", pos: start}
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
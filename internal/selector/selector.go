@@ -0,0 +1,309 @@
+// Package selector parses and evaluates subscription filter expressions
+// over message data.
+//
+// An expression combines predicates on fields of a JSON object:
+//
+//	symbol == "AAPL"
+//	price >= 10 && price < 20
+//	symbol in ("AAPL", "MSFT") || (venue != 'XNAS' && !halted == true)
+//
+// Fields are dotted paths into nested objects. Literals are strings in
+// single or double quotes, numbers, true, false and null. Comparisons
+// between values of different types, or on missing fields, are false.
+package selector
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// MaxLength is the longest expression Parse accepts.
+const MaxLength = 1024
+
+// maxDepth bounds the nesting of parentheses and negations.
+const maxDepth = 32
+
+// ErrTooLong is returned for expressions longer than MaxLength.
+var ErrTooLong = errors.New("selector: expression too long")
+
+// Selector is a parsed filter expression. It is safe for concurrent use.
+type Selector struct {
+	expr string
+	root node
+}
+
+// Parse parses a filter expression.
+func Parse(expr string) (*Selector, error) {
+	if len(expr) > MaxLength {
+		return nil, ErrTooLong
+	}
+	p := &parser{lex: lexer{src: expr}}
+	p.next()
+	root, err := p.parseOr(0)
+	if err != nil {
+		return nil, err
+	}
+	if p.tok.kind != tokEOF {
+		return nil, p.errorf("unexpected %s", p.tok)
+	}
+	return &Selector{expr: expr, root: root}, nil
+}
+
+// String returns the expression the selector was parsed from.
+func (s *Selector) String() string {
+	return s.expr
+}
+
+// Match reports whether data, as decoded by encoding/json into an
+// interface{}, satisfies the expression.
+func (s *Selector) Match(data interface{}) bool {
+	return s.root.eval(data)
+}
+
+type node interface {
+	eval(data interface{}) bool
+}
+
+type orNode struct{ left, right node }
+
+func (n orNode) eval(data interface{}) bool { return n.left.eval(data) || n.right.eval(data) }
+
+type andNode struct{ left, right node }
+
+func (n andNode) eval(data interface{}) bool { return n.left.eval(data) && n.right.eval(data) }
+
+type notNode struct{ operand node }
+
+func (n notNode) eval(data interface{}) bool { return !n.operand.eval(data) }
+
+type compareNode struct {
+	path  []string
+	op    string
+	value interface{}
+}
+
+func (n compareNode) eval(data interface{}) bool {
+	v, ok := lookup(data, n.path)
+	return ok && compare(v, n.op, n.value)
+}
+
+type inNode struct {
+	path   []string
+	values []interface{}
+}
+
+func (n inNode) eval(data interface{}) bool {
+	v, ok := lookup(data, n.path)
+	if !ok {
+		return false
+	}
+	for _, want := range n.values {
+		if compare(v, "==", want) {
+			return true
+		}
+	}
+	return false
+}
+
+func lookup(data interface{}, path []string) (interface{}, bool) {
+	for _, name := range path {
+		obj, ok := data.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if data, ok = obj[name]; !ok {
+			return nil, false
+		}
+	}
+	return data, true
+}
+
+func compare(v interface{}, op string, want interface{}) bool {
+	switch want := want.(type) {
+	case float64:
+		got, ok := v.(float64)
+		return ok && ordered(got, want, op)
+	case string:
+		got, ok := v.(string)
+		return ok && ordered(got, want, op)
+	case bool:
+		got, ok := v.(bool)
+		return ok && equality(got == want, op)
+	case nil:
+		return equality(v == nil, op)
+	}
+	return false
+}
+
+func ordered[T float64 | string](a, b T, op string) bool {
+	switch op {
+	case "<":
+		return a < b
+	case "<=":
+		return a <= b
+	case ">":
+		return a > b
+	case ">=":
+		return a >= b
+	}
+	return equality(a == b, op)
+}
+
+func equality(equal bool, op string) bool {
+	switch op {
+	case "==":
+		return equal
+	case "!=":
+		return !equal
+	}
+	return false
+}
+
+type parser struct {
+	lex lexer
+	tok token
+}
+
+func (p *parser) next() {
+	p.tok = p.lex.next()
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("selector: at offset %d: %s", p.tok.pos, fmt.Sprintf(format, args...))
+}
+
+func (p *parser) parseOr(depth int) (node, error) {
+	left, err := p.parseAnd(depth)
+	if err != nil {
+		return nil, err
+	}
+	for p.tok.kind == tokOr {
+		p.next()
+		right, err := p.parseAnd(depth)
+		if err != nil {
+			return nil, err
+		}
+		left = orNode{left, right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd(depth int) (node, error) {
+	left, err := p.parseUnary(depth)
+	if err != nil {
+		return nil, err
+	}
+	for p.tok.kind == tokAnd {
+		p.next()
+		right, err := p.parseUnary(depth)
+		if err != nil {
+			return nil, err
+		}
+		left = andNode{left, right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseUnary(depth int) (node, error) {
+	if depth > maxDepth {
+		return nil, p.errorf("expression nested too deeply")
+	}
+	switch p.tok.kind {
+	case tokNot:
+		p.next()
+		operand, err := p.parseUnary(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		return notNode{operand}, nil
+	case tokLParen:
+		p.next()
+		inner, err := p.parseOr(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		if p.tok.kind != tokRParen {
+			return nil, p.errorf("expected ) but found %s", p.tok)
+		}
+		p.next()
+		return inner, nil
+	}
+	return p.parsePredicate()
+}
+
+func (p *parser) parsePredicate() (node, error) {
+	if p.tok.kind != tokIdent {
+		return nil, p.errorf("expected field name but found %s", p.tok)
+	}
+	path := strings.Split(p.tok.text, ".")
+	for _, name := range path {
+		if name == "" {
+			return nil, p.errorf("invalid field name %q", p.tok.text)
+		}
+	}
+	p.next()
+	switch p.tok.kind {
+	case tokOp:
+		op := p.tok.text
+		p.next()
+		value, err := p.parseLiteral()
+		if err != nil {
+			return nil, err
+		}
+		if _, isBool := value.(bool); (isBool || value == nil) && op != "==" && op != "!=" {
+			return nil, p.errorf("operator %s needs a number or string", op)
+		}
+		return compareNode{path: path, op: op, value: value}, nil
+	case tokIn:
+		p.next()
+		if p.tok.kind != tokLParen {
+			return nil, p.errorf("expected ( after in but found %s", p.tok)
+		}
+		p.next()
+		var values []interface{}
+		for {
+			value, err := p.parseLiteral()
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, value)
+			if p.tok.kind == tokRParen {
+				p.next()
+				return inNode{path: path, values: values}, nil
+			}
+			if p.tok.kind != tokComma {
+				return nil, p.errorf("expected , or ) but found %s", p.tok)
+			}
+			p.next()
+		}
+	}
+	return nil, p.errorf("expected operator after %s but found %s", strings.Join(path, "."), p.tok)
+}
+
+func (p *parser) parseLiteral() (interface{}, error) {
+	tok := p.tok
+	switch tok.kind {
+	case tokString:
+		p.next()
+		return tok.text, nil
+	case tokNumber:
+		f, err := strconv.ParseFloat(tok.text, 64)
+		if err != nil {
+			return nil, p.errorf("invalid number %s", tok.text)
+		}
+		p.next()
+		return f, nil
+	case tokIdent:
+		switch tok.text {
+		case "true", "false":
+			p.next()
+			return tok.text == "true", nil
+		case "null":
+			p.next()
+			return nil, nil
+		}
+	}
+	return nil, p.errorf("expected value but found %s", tok)
+}
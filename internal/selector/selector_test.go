@@ -0,0 +1,108 @@
+package selector
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func decode(t *testing.T, s string) interface{} {
+	t.Helper()
+	var v interface{}
+	if err := json.Unmarshal([]byte(s), &v); err != nil {
+		t.Fatalf("decode %s: %v", s, err)
+	}
+	return v
+}
+
+func TestMatch(t *testing.T) {
+	data := `{"symbol":"AAPL","price":150.5,"halted":false,"venue":null,"quote":{"bid":150,"ask":151}}`
+	cases := []struct {
+		expr string
+		want bool
+	}{
+		{`symbol == "AAPL"`, true},
+		{`symbol == 'MSFT'`, false},
+		{`symbol != "MSFT"`, true},
+		{`price > 150`, true},
+		{`price >= 150.5 && price <= 150.5`, true},
+		{`price < 100`, false},
+		{`symbol < "B"`, true},
+		{`symbol in ("MSFT", "AAPL")`, true},
+		{`symbol in ("MSFT")`, false},
+		{`halted == false`, true},
+		{`venue == null`, true},
+		{`quote.bid >= 150 && quote.ask < 152`, true},
+		{`quote.mid > 0`, false},
+		{`missing != 1`, false},
+		{`price == "150.5"`, false},
+		{`!(symbol == "AAPL")`, false},
+		{`symbol == "MSFT" || price > 100 && halted == false`, true},
+		{`(symbol == "MSFT" || price > 100) && halted == true`, false},
+		{`symbol == "A\"B"`, false},
+	}
+	for _, c := range cases {
+		sel, err := Parse(c.expr)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", c.expr, err)
+			continue
+		}
+		if got := sel.Match(decode(t, data)); got != c.want {
+			t.Errorf("%q: expected %v, got %v", c.expr, c.want, got)
+		}
+	}
+}
+
+func TestMatchNonObject(t *testing.T) {
+	sel, err := Parse(`x == 1`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, data := range []string{`1`, `"x"`, `[1]`, `null`} {
+		if sel.Match(decode(t, data)) {
+			t.Errorf("expected %s not to match", data)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, expr := range []string{
+		``,
+		`symbol`,
+		`symbol ==`,
+		`symbol = "AAPL"`,
+		`== 1`,
+		`symbol == "AAPL`,
+		`symbol in "AAPL"`,
+		`symbol in ("AAPL"`,
+		`symbol in ()`,
+		`price > 1 &&`,
+		`(price > 1`,
+		`price > 1)`,
+		`price > true`,
+		`price < null`,
+		`price > 1e`,
+		`a..b == 1`,
+		`price > 1 # comment`,
+		strings.Repeat("(", 40) + "a == 1" + strings.Repeat(")", 40),
+	} {
+		if _, err := Parse(expr); err == nil {
+			t.Errorf("expected Parse(%q) to fail", expr)
+		}
+	}
+	if _, err := Parse(strings.Repeat(" ", MaxLength+1)); err != ErrTooLong {
+		t.Errorf("expected ErrTooLong, got %v", err)
+	}
+}
+
+func FuzzParse(f *testing.F) {
+	f.Add(`symbol in ("AAPL", 'MSFT') && !(price < 10.5 || halted == true)`)
+	f.Add(`a.b.c != null`)
+	f.Fuzz(func(t *testing.T, expr string) {
+		sel, err := Parse(expr)
+		if err != nil {
+			return
+		}
+		sel.Match(map[string]interface{}{"a": 1.0, "symbol": "AAPL"})
+	})
+}
@@ -1,5 +1,14 @@
 package message
 
+import (
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// ErrNoData is returned by DecodeData when a message carries no data payload.
+var ErrNoData = errors.New("message: no data")
+
 // Advice provides connection and reconnection instructions to Bayeux clients.
 // It is typically included in /meta/handshake and /meta/connect responses
 // to inform clients how to handle reconnection, intervals, and timeouts.
@@ -13,6 +22,13 @@ type Advice struct {
 
 	// Timeout is the maximum time in milliseconds the server will hold a long-polling request.
 	Timeout int `json:"timeout,omitempty"`
+
+	// MultipleClients is set when the server detects several clients sharing one browser.
+	// Such clients are not held and should poll at Interval instead.
+	MultipleClients bool `json:"multiple-clients,omitempty"`
+
+	// Hosts lists alternative host names the client may connect to.
+	Hosts []string `json:"hosts,omitempty"`
 }
 
 // BayeuxMessage represents a message in the Bayeux protocol.
@@ -23,11 +39,17 @@ type BayeuxMessage struct {
 	// Channel is the destination or meta channel for the message (e.g., "/meta/handshake", "/foo/bar").
 	Channel string `json:"channel"`
 
+	// Version is the Bayeux protocol version, exchanged during handshake.
+	Version string `json:"version,omitempty"`
+
 	// ClientID is the unique identifier for the client session, assigned by the server during handshake.
 	ClientID string `json:"clientId,omitempty"`
 
 	// Data contains the payload for publish messages or additional information for meta messages.
-	Data map[string]interface{} `json:"data,omitempty"`
+	// It holds any JSON value (object, array, string, number, boolean) as raw bytes,
+	// so it is delivered to subscribers exactly as published without being re-encoded.
+	// Use DecodeData and SetData to convert to and from Go values.
+	Data json.RawMessage `json:"data,omitempty"`
 
 	// ID is an optional unique identifier for correlating requests and responses.
 	ID string `json:"id,omitempty"`
@@ -43,4 +65,80 @@ type BayeuxMessage struct {
 
 	// Advice provides connection advice to the client, typically included in handshake and connect responses.
 	Advice *Advice `json:"advice,omitempty"`
+
+	// ConnectionType names the transport used by a /meta/connect request (e.g., "long-polling").
+	ConnectionType string `json:"connectionType,omitempty"`
+
+	// SupportedConnectionTypes lists the transports a client or server supports, exchanged during handshake.
+	SupportedConnectionTypes []string `json:"supportedConnectionTypes,omitempty"`
+
+	// Lazy marks a message published from Go code as not urgent: subscribers
+	// receive it with their next batch of messages instead of immediately.
+	// It is never sent over the wire.
+	Lazy bool `json:"-"`
+
+	// Expires, if set, is when the message goes stale: subscribers that
+	// have not received it by then never will. It is never sent over the
+	// wire.
+	Expires time.Time `json:"-"`
+
+	// ConflationKey, on a conflating channel, identifies what the message
+	// is about, such as a ticker symbol: a subscriber that has not yet
+	// received an earlier message with the same channel and key receives
+	// only this newer one, in the earlier one's place. It is never sent
+	// over the wire.
+	ConflationKey string `json:"-"`
+
+	// Ext carries extension fields, such as authentication credentials, keyed by name.
+	// Values are kept as raw JSON; use DecodeExt to read one.
+	Ext map[string]json.RawMessage `json:"ext,omitempty"`
+}
+
+// NewData encodes v as JSON for use as a BayeuxMessage Data payload.
+func NewData(v interface{}) (json.RawMessage, error) {
+	return json.Marshal(v)
+}
+
+// MustData is like NewData but panics if v cannot be encoded.
+// It is intended for literals in tests and examples.
+func MustData(v interface{}) json.RawMessage {
+	data, err := NewData(v)
+	if err != nil {
+		panic(err)
+	}
+	return data
+}
+
+// HasData reports whether the message carries a data payload.
+func (m *BayeuxMessage) HasData() bool {
+	return len(m.Data) > 0
+}
+
+// DecodeData unmarshals the message Data payload into v.
+// It returns an error if the message has no data.
+func (m *BayeuxMessage) DecodeData(v interface{}) error {
+	if !m.HasData() {
+		return ErrNoData
+	}
+	return json.Unmarshal(m.Data, v)
+}
+
+// SetData encodes v as JSON and stores it as the message Data payload.
+func (m *BayeuxMessage) SetData(v interface{}) error {
+	data, err := NewData(v)
+	if err != nil {
+		return err
+	}
+	m.Data = data
+	return nil
+}
+
+// DecodeExt unmarshals the named extension field into v,
+// reporting false if the message has no such field.
+func (m *BayeuxMessage) DecodeExt(name string, v interface{}) (bool, error) {
+	raw, ok := m.Ext[name]
+	if !ok {
+		return false, nil
+	}
+	return true, json.Unmarshal(raw, v)
 }
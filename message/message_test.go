@@ -1,6 +1,7 @@
 package message
 
 import (
+	"bytes"
 	"encoding/json"
 	"testing"
 )
@@ -9,7 +10,7 @@ func TestMarshalUnmarshalBayeuxMessage(t *testing.T) {
 	original := BayeuxMessage{
 		Channel:      "/meta/handshake",
 		ClientID:     "abc123",
-		Data:         map[string]interface{}{"foo": "bar"},
+		Data:         MustData(map[string]interface{}{"foo": "bar"}),
 		ID:           "msg1",
 		Subscription: "/chat/room1",
 		Successful:   boolPtr(true),
@@ -36,9 +37,101 @@ func TestMarshalUnmarshalBayeuxMessage(t *testing.T) {
 		t.Errorf("Decoded message does not match original message")
 	}
 
-	if decoded.Data["foo"] != "bar" {
+	var payload map[string]interface{}
+	if err := decoded.DecodeData(&payload); err != nil {
+		t.Fatalf("DecodeData failed: %v", err)
+	}
+	if payload["foo"] != "bar" {
 		t.Errorf("Decoded data does not match original message")
 	}
 }
 
+func TestNonObjectData(t *testing.T) {
+	payloads := []string{`[1,2,3]`, `"hello"`, `42`, `true`, `{"nested":{"a":[1]}}`}
+	for _, p := range payloads {
+		raw := []byte(`{"channel":"/foo","data":` + p + `}`)
+		var msg BayeuxMessage
+		if err := json.Unmarshal(raw, &msg); err != nil {
+			t.Fatalf("Unmarshal %s failed: %v", p, err)
+		}
+		if string(msg.Data) != p {
+			t.Errorf("Expected raw data %s, got %s", p, msg.Data)
+		}
+		out, err := json.Marshal(msg)
+		if err != nil {
+			t.Fatalf("Marshal failed: %v", err)
+		}
+		if string(out) != string(raw) {
+			t.Errorf("Expected %s, got %s", raw, out)
+		}
+	}
+}
+
+func TestDecodeDataTyped(t *testing.T) {
+	type tick struct {
+		Symbol string  `json:"symbol"`
+		Price  float64 `json:"price"`
+	}
+	var msg BayeuxMessage
+	if err := msg.DecodeData(&tick{}); err != ErrNoData {
+		t.Errorf("Expected ErrNoData, got %v", err)
+	}
+	if err := msg.SetData(tick{Symbol: "ACME", Price: 12.5}); err != nil {
+		t.Fatalf("SetData failed: %v", err)
+	}
+	var got tick
+	if err := msg.DecodeData(&got); err != nil {
+		t.Fatalf("DecodeData failed: %v", err)
+	}
+	if got.Symbol != "ACME" || got.Price != 12.5 {
+		t.Errorf("Unexpected decoded value: %+v", got)
+	}
+}
+
 func boolPtr(b bool) *bool { return &b }
+
+func TestDecodeExt(t *testing.T) {
+	var msg BayeuxMessage
+	if err := json.Unmarshal([]byte(`{"channel":"/meta/handshake","ext":{"token":"abc","ttl":5}}`), &msg); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	var token string
+	if ok, err := msg.DecodeExt("token", &token); !ok || err != nil || token != "abc" {
+		t.Errorf("Expected token 'abc', got %q (%v, %v)", token, ok, err)
+	}
+	var ttl string
+	if ok, err := msg.DecodeExt("ttl", &ttl); !ok || err == nil {
+		t.Errorf("Expected type error decoding ttl as string")
+	}
+	if ok, _ := msg.DecodeExt("missing", &token); ok {
+		t.Errorf("Expected missing field to be reported")
+	}
+}
+
+func FuzzBayeuxMessageRoundTrip(f *testing.F) {
+	f.Add([]byte(`{"channel":"/meta/handshake","version":"1.0","supportedConnectionTypes":["long-polling"]}`))
+	f.Add([]byte(`{"channel":"/chat","clientId":"abc","data":{"text":"hi","n":[1,2.5,null]},"id":"7"}`))
+	f.Add([]byte(`{"channel":"/meta/connect","advice":{"reconnect":"retry","timeout":0},"ext":{"token":"x"}}`))
+	f.Add([]byte(`{"successful":false,"error":"402::Unknown client","data":"é"}`))
+	f.Fuzz(func(t *testing.T, input []byte) {
+		var msg BayeuxMessage
+		if err := json.Unmarshal(input, &msg); err != nil {
+			return
+		}
+		first, err := json.Marshal(&msg)
+		if err != nil {
+			t.Fatalf("Marshal of decoded message failed: %v", err)
+		}
+		var again BayeuxMessage
+		if err := json.Unmarshal(first, &again); err != nil {
+			t.Fatalf("Unmarshal of encoded message failed: %v\n%s", err, first)
+		}
+		second, err := json.Marshal(&again)
+		if err != nil {
+			t.Fatalf("Second marshal failed: %v", err)
+		}
+		if !bytes.Equal(first, second) {
+			t.Errorf("Round trip is not stable:\n%s\n%s", first, second)
+		}
+	})
+}
@@ -0,0 +1,39 @@
+package server
+
+import (
+	"context"
+
+	"github.com/charlinchui/galliard/internal/client"
+	"github.com/charlinchui/galliard/message"
+)
+
+// Principal is the authenticated identity attached to a session.
+type Principal = client.Principal
+
+// Authenticator verifies the credentials a client presents during handshake.
+// Credentials may come from the handshake message (typically its Ext) or
+// from the transport Request carried by ctx.
+//
+// Authenticate returns the identity to attach to the new session, which may
+// be nil for anonymous clients. An error rejects the handshake with a
+// "401::" error whose message is the error text.
+type Authenticator interface {
+	Authenticate(ctx context.Context, msg *message.BayeuxMessage) (*Principal, error)
+}
+
+// AuthenticatorFunc adapts a function to the Authenticator interface.
+type AuthenticatorFunc func(ctx context.Context, msg *message.BayeuxMessage) (*Principal, error)
+
+func (f AuthenticatorFunc) Authenticate(ctx context.Context, msg *message.BayeuxMessage) (*Principal, error) {
+	return f(ctx, msg)
+}
+
+// Principal returns the identity attached to a session during handshake,
+// or nil if the session is unknown or anonymous.
+func (s *Server) Principal(clientID string) *Principal {
+	sess := s.getSession(clientID)
+	if sess == nil {
+		return nil
+	}
+	return sess.Principal()
+}
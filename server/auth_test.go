@@ -0,0 +1,47 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/charlinchui/galliard/message"
+)
+
+func TestAuthenticatorAttachesPrincipal(t *testing.T) {
+	auth := AuthenticatorFunc(func(ctx context.Context, msg *message.BayeuxMessage) (*Principal, error) {
+		var user string
+		if ok, _ := msg.DecodeExt("user", &user); !ok {
+			return nil, errors.New("who are you?")
+		}
+		return &Principal{Subject: user}, nil
+	})
+	srv, err := New(WithAuthenticator(auth))
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer srv.Close()
+
+	resp := srv.HandleMessage(&message.BayeuxMessage{
+		Channel: "/meta/handshake",
+		Ext:     map[string]json.RawMessage{"user": message.MustData("bob")},
+	})
+	if p := srv.Principal(resp.ClientID); p == nil || p.Subject != "bob" {
+		t.Fatalf("Expected principal bob, got %+v", p)
+	}
+	if infos := srv.SessionList(); infos[0].Principal != "bob" {
+		t.Errorf("Expected session list to report principal, got %+v", infos[0])
+	}
+
+	resp = srv.HandleMessage(&message.BayeuxMessage{Channel: "/meta/handshake"})
+	if resp.Successful == nil || *resp.Successful || resp.Error != "401::who are you?" {
+		t.Errorf("Expected 401 rejection, got %+v", resp)
+	}
+	if resp.Advice == nil || resp.Advice.Reconnect != "none" {
+		t.Errorf("Expected reconnect none advice, got %+v", resp.Advice)
+	}
+	if srv.Principal("unknown") != nil {
+		t.Errorf("Expected no principal for unknown session")
+	}
+}
@@ -0,0 +1,241 @@
+package server
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/charlinchui/galliard/internal/channel"
+	"github.com/charlinchui/galliard/message"
+)
+
+// Channel is a handle to one of the server's channels, through which Go
+// code can attach behaviour and data to it. Handles are obtained from
+// Server.Channel and are passed to channel initializers and listeners.
+//
+// Settings live as long as the channel: once an unused channel is swept,
+// a later use creates a fresh one. Configure channels in a
+// ChannelInitializer, or mark them persistent, to keep their settings.
+type Channel struct {
+	ch *channel.Channel
+	s  *Server
+}
+
+func (s *Server) channelHandle(ch *channel.Channel) *Channel {
+	return &Channel{ch: ch, s: s}
+}
+
+// Channel returns a handle to the named channel, creating it if needed.
+// It returns ErrInvalidChannel for malformed names and meta channels.
+func (s *Server) Channel(name string) (*Channel, error) {
+	if !channel.Valid(name) || strings.HasPrefix(name, "/meta/") {
+		return nil, ErrInvalidChannel
+	}
+	return s.channelHandle(s.getOrCreateChannel(name)), nil
+}
+
+// Name returns the channel name.
+func (c *Channel) Name() string {
+	return c.ch.Name
+}
+
+// Persistent reports whether the channel is kept while unused.
+func (c *Channel) Persistent() bool {
+	return c.ch.Persistent()
+}
+
+// SetPersistent marks whether the channel is kept while unused. Channels
+// that are not persistent are removed once they have had no subscribers
+// and no activity for the channel sweep period.
+func (c *Channel) SetPersistent(persistent bool) {
+	c.ch.SetPersistent(persistent)
+}
+
+// SubscriberCount returns the number of sessions subscribed to the channel.
+func (c *Channel) SubscriberCount() int {
+	return c.ch.SubscriberCount()
+}
+
+// TTL returns how long messages published to the channel may wait in
+// subscriber queues, or zero if they do not expire.
+func (c *Channel) TTL() time.Duration {
+	return c.ch.TTL()
+}
+
+// SetTTL sets how long messages published to the channel may wait in
+// subscriber queues before they are discarded as stale. Zero means they do
+// not expire. A publisher's TTLExt field takes precedence.
+func (c *Channel) SetTTL(ttl time.Duration) {
+	c.ch.SetTTL(ttl)
+}
+
+// Retained returns the message the channel retains for new subscribers,
+// or nil if there is none. See WithRetainedChannels.
+func (c *Channel) Retained() *message.BayeuxMessage {
+	return c.ch.Retained()
+}
+
+// ClearRetained discards the retained message of the channel, including
+// from the message store, if one is configured. It returns the store's
+// error, if any. The message is cleared after messages already published
+// to the channel are delivered, so none of them is retained in its place.
+// It must not be called from a MessageStore.
+func (c *Channel) ClearRetained() error {
+	done := make(chan error, 1)
+	c.s.fanout.Submit(c.ch.Name, func() {
+		c.ch.SetRetained(nil)
+		if c.s.opts.store == nil {
+			done <- nil
+			return
+		}
+		done <- c.s.opts.store.Delete(c.ch.Name)
+	})
+	return <-done
+}
+
+// PublishInterceptor inspects, and may modify, a message published to a
+// channel before it is delivered. Returning an error rejects the message;
+// a client publisher receives a "403:<channel>:<error>" reply.
+// Interceptors run on the publisher's goroutine and only for messages
+// published to the channel itself, not to channels its name matches.
+type PublishInterceptor func(ctx context.Context, ch *Channel, msg *message.BayeuxMessage) error
+
+// AddPublishInterceptor adds f to the interceptors run, in the order they
+// were added, on every message published to the channel.
+func (c *Channel) AddPublishInterceptor(f PublishInterceptor) {
+	c.ch.AddInterceptor(func(ctx context.Context, msg *message.BayeuxMessage) error {
+		return f(ctx, c, msg)
+	})
+}
+
+// SubscriptionListener is notified after a client subscribes to or
+// unsubscribes from a channel, including when its session ends.
+type SubscriptionListener interface {
+	Subscribed(ch *Channel, clientID string)
+	Unsubscribed(ch *Channel, clientID string)
+}
+
+// AddSubscriptionListener adds l to the listeners of the channel.
+func (c *Channel) AddSubscriptionListener(l SubscriptionListener) {
+	c.ch.AddSubscriptionListener(func(clientID string, subscribed bool) {
+		if subscribed {
+			l.Subscribed(c, clientID)
+		} else {
+			l.Unsubscribed(c, clientID)
+		}
+	})
+}
+
+// SetAttribute stores value under name on the channel.
+func (c *Channel) SetAttribute(name string, value interface{}) {
+	c.ch.SetAttribute(name, value)
+}
+
+// Attribute returns the value stored under name on the channel.
+func (c *Channel) Attribute(name string) (interface{}, bool) {
+	return c.ch.Attribute(name)
+}
+
+// RemoveAttribute deletes the value stored under name on the channel.
+func (c *Channel) RemoveAttribute(name string) {
+	c.ch.RemoveAttribute(name)
+}
+
+// AttributeNames returns the names of the channel's attributes in sorted order.
+func (c *Channel) AttributeNames() []string {
+	return c.ch.AttributeNames()
+}
+
+// ChannelAttribute returns the attribute name of ch if it is set and holds
+// a T.
+func ChannelAttribute[T any](ch *Channel, name string) (T, bool) {
+	v, ok := ch.Attribute(name)
+	if !ok {
+		var zero T
+		return zero, false
+	}
+	t, ok := v.(T)
+	return t, ok
+}
+
+// ChannelListener is notified when channels are created and removed.
+// Callbacks run on the goroutine that caused the change and must not block.
+type ChannelListener interface {
+	ChannelAdded(ch *Channel)
+	ChannelRemoved(ch *Channel)
+}
+
+// ChannelInitializer configures a channel before it is first used, for
+// example marking it persistent. Initializers may run for a channel that
+// loses a creation race with another goroutine and is discarded, so they
+// should only configure the channel they are given.
+type ChannelInitializer func(ch *Channel)
+
+func (s *Server) getChannel(name string) (*channel.Channel, bool) {
+	ch, ok := s.channels.get(name)
+	if !ok || ch.Removed() {
+		return nil, false
+	}
+	return ch, true
+}
+
+// getOrCreateChannel returns the named channel, creating and initializing
+// it if needed. A channel being removed is replaced by a new one.
+func (s *Server) getOrCreateChannel(name string) *channel.Channel {
+	for {
+		if ch, ok := s.channels.get(name); ok {
+			if !ch.Removed() {
+				return ch
+			}
+			s.channels.deleteIf(name, func(c *channel.Channel) bool { return c == ch })
+			continue
+		}
+		ch := channel.NewChannel(name)
+		ch.Lazy = matchAny(s.opts.lazyChannels, name)
+		ch.Conflate = matchAny(s.opts.conflatingChannels, name)
+		if ch.Retain = matchAny(s.opts.retainedChannels, name); ch.Retain {
+			s.loadRetained(ch)
+		}
+		ch.SetPersistent(matchAny(s.opts.persistentChannels, name))
+		for _, t := range s.opts.messageTTLs {
+			if matchAny(t.patterns, name) {
+				ch.SetTTL(t.ttl)
+			}
+		}
+		ch.Touch(s.opts.clock.Now())
+		for _, init := range s.opts.channelInitializers {
+			init(s.channelHandle(ch))
+		}
+		if _, stored := s.channels.putIfAbsent(name, ch); stored {
+			for _, l := range s.opts.channelListeners {
+				l.ChannelAdded(s.channelHandle(ch))
+			}
+			return ch
+		}
+	}
+}
+
+// sweepChannels removes channels that are not persistent and have had no
+// subscribers and no activity for the channel sweep period.
+func (s *Server) sweepChannels() {
+	idleSince := s.opts.clock.Now().Add(-s.opts.channelSweep)
+	for _, ch := range s.channels.values() {
+		if !ch.Remove(idleSince) {
+			continue
+		}
+		if s.channels.deleteIf(ch.Name, func(c *channel.Channel) bool { return c == ch }) {
+			for _, l := range s.opts.channelListeners {
+				l.ChannelRemoved(s.channelHandle(ch))
+			}
+		}
+	}
+}
+
+func matchAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if channel.Match(pattern, name) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,279 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/charlinchui/galliard/message"
+)
+
+type recordingListener struct {
+	mu      sync.Mutex
+	added   []string
+	removed []string
+}
+
+func (l *recordingListener) ChannelAdded(ch *Channel) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.added = append(l.added, ch.Name())
+}
+
+func (l *recordingListener) ChannelRemoved(ch *Channel) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.removed = append(l.removed, ch.Name())
+}
+
+func TestUnsubscribeDoesNotCreateChannel(t *testing.T) {
+	srv := NewServer()
+	defer srv.Close()
+	clientID := srv.HandleMessage(&message.BayeuxMessage{Channel: "/meta/handshake"}).ClientID
+	resp := srv.HandleMessage(&message.BayeuxMessage{Channel: "/meta/unsubscribe", ClientID: clientID, Subscription: "/never/used"})
+	if resp.Successful == nil || !*resp.Successful {
+		t.Errorf("Expected unsubscribe to succeed, got %+v", resp)
+	}
+	if n := srv.ChannelCount(); n != 0 {
+		t.Errorf("Expected no channels, got %d", n)
+	}
+}
+
+func TestSweepRemovesIdleChannels(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	listener := &recordingListener{}
+	srv, err := New(
+		WithClock(clock),
+		WithChannelSweep(time.Minute),
+		WithPersistentChannels("/rooms/**"),
+		WithChannelInitializer(func(ch *Channel) {
+			if ch.Name() == "/lobby" {
+				ch.SetPersistent(true)
+			}
+		}),
+		WithChannelListener(listener),
+	)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer srv.Close()
+	clientID := srv.HandleMessage(&message.BayeuxMessage{Channel: "/meta/handshake"}).ClientID
+	for _, ch := range []string{"/idle", "/lobby", "/rooms/a", "/busy"} {
+		srv.HandleMessage(&message.BayeuxMessage{Channel: ch, ClientID: clientID, Data: message.MustData(1)})
+	}
+	srv.HandleMessage(&message.BayeuxMessage{Channel: "/meta/subscribe", ClientID: clientID, Subscription: "/busy"})
+
+	clock.Advance(30 * time.Second)
+	srv.sweepChannels()
+	if n := srv.ChannelCount(); n != 4 {
+		t.Fatalf("Expected recently used channels to be kept, got %d", n)
+	}
+
+	clock.Advance(time.Minute)
+	srv.sweepChannels()
+	var names []string
+	for _, info := range srv.ChannelList() {
+		names = append(names, info.Name)
+	}
+	if len(names) != 3 || names[0] != "/busy" || names[1] != "/lobby" || names[2] != "/rooms/a" {
+		t.Errorf("Expected only /idle to be swept, got %v", names)
+	}
+	if len(listener.added) != 4 || len(listener.removed) != 1 || listener.removed[0] != "/idle" {
+		t.Errorf("Unexpected listener calls: added %v, removed %v", listener.added, listener.removed)
+	}
+
+	srv.HandleMessage(&message.BayeuxMessage{Channel: "/meta/subscribe", ClientID: clientID, Subscription: "/idle"})
+	if subs, ok := srv.ChannelSubscribers("/idle"); !ok || len(subs) != 1 {
+		t.Errorf("Expected swept channel to be recreated on subscribe, got %v", subs)
+	}
+}
+
+func TestChannelSweepValidation(t *testing.T) {
+	if _, err := New(WithChannelSweep(-time.Second)); err == nil {
+		t.Error("Expected negative channel sweep to be rejected")
+	}
+}
+
+type recordingSubscriptions struct {
+	mu     sync.Mutex
+	events []string
+}
+
+func (r *recordingSubscriptions) Subscribed(ch *Channel, clientID string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, "+"+ch.Name())
+}
+
+func (r *recordingSubscriptions) Unsubscribed(ch *Channel, clientID string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, "-"+ch.Name())
+}
+
+func TestChannelHandle(t *testing.T) {
+	srv := NewServer()
+	defer srv.Close()
+	if _, err := srv.Channel("/meta/connect"); err != ErrInvalidChannel {
+		t.Errorf("Expected ErrInvalidChannel for a meta channel, got %v", err)
+	}
+	if _, err := srv.Channel("no-slash"); err != ErrInvalidChannel {
+		t.Errorf("Expected ErrInvalidChannel for a malformed name, got %v", err)
+	}
+
+	room, err := srv.Channel("/rooms/go")
+	if err != nil {
+		t.Fatalf("Channel failed: %v", err)
+	}
+	room.SetAttribute("topic", "Generics")
+	room.SetAttribute("owner", 7)
+	again, _ := srv.Channel("/rooms/go")
+	if topic, ok := ChannelAttribute[string](again, "topic"); !ok || topic != "Generics" {
+		t.Errorf("Expected topic attribute through a second handle, got %q", topic)
+	}
+	if _, ok := ChannelAttribute[string](again, "owner"); ok {
+		t.Errorf("Expected attribute of another type not to be returned")
+	}
+	if owner, ok := ChannelAttribute[int](again, "owner"); !ok || owner != 7 {
+		t.Errorf("Expected owner 7, got %d", owner)
+	}
+}
+
+func TestPublishInterceptor(t *testing.T) {
+	srv := NewServer()
+	defer srv.Close()
+	chat, _ := srv.Channel("/chat")
+	chat.AddPublishInterceptor(func(_ context.Context, ch *Channel, msg *message.BayeuxMessage) error {
+		var text string
+		if err := msg.DecodeData(&text); err != nil {
+			return err
+		}
+		if text == "spam" {
+			return errors.New("spam not allowed")
+		}
+		return msg.SetData(strings.ToUpper(text))
+	})
+	clientID := srv.HandleMessage(&message.BayeuxMessage{Channel: "/meta/handshake"}).ClientID
+	srv.HandleMessage(&message.BayeuxMessage{Channel: "/meta/subscribe", ClientID: clientID, Subscription: "/chat"})
+
+	resp := srv.HandleMessage(&message.BayeuxMessage{Channel: "/chat", ClientID: clientID, Data: message.MustData("spam")})
+	if resp.Successful == nil || *resp.Successful || resp.Error != "403:/chat:spam not allowed" {
+		t.Errorf("Expected rejected publish, got %+v", resp)
+	}
+	if err := srv.Publish("/chat", message.MustData("spam")); !errors.Is(err, ErrRejected) || err.Error() != "server: publish rejected: spam not allowed" {
+		t.Errorf("Expected server publish to be rejected too, got %v", err)
+	}
+	srv.HandleMessage(&message.BayeuxMessage{Channel: "/chat", ClientID: clientID, Data: message.MustData("hello")})
+	waitForFanout(t, srv)
+	msgs := srv.getSession(clientID).DequeueAll()
+	if len(msgs) != 1 || string(msgs[0].Data) != `"HELLO"` {
+		t.Errorf("Expected only the rewritten message to be delivered, got %+v", msgs)
+	}
+}
+
+func TestSubscriptionListener(t *testing.T) {
+	rec := &recordingSubscriptions{}
+	srv, err := New(WithChannelInitializer(func(ch *Channel) {
+		ch.AddSubscriptionListener(rec)
+	}))
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer srv.Close()
+	clientID := srv.HandleMessage(&message.BayeuxMessage{Channel: "/meta/handshake"}).ClientID
+	srv.HandleMessage(&message.BayeuxMessage{Channel: "/meta/subscribe", ClientID: clientID, Subscription: "/a"})
+	srv.HandleMessage(&message.BayeuxMessage{Channel: "/meta/subscribe", ClientID: clientID, Subscription: "/b"})
+	srv.HandleMessage(&message.BayeuxMessage{Channel: "/meta/unsubscribe", ClientID: clientID, Subscription: "/a"})
+	srv.Disconnect(clientID)
+
+	want := []string{"+/a", "+/b", "-/a", "-/b"}
+	if strings.Join(rec.events, ",") != strings.Join(want, ",") {
+		t.Errorf("Expected events %v, got %v", want, rec.events)
+	}
+}
+
+func TestMessageTTL(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	metrics := newRecordingMetrics()
+	srv, err := New(WithClock(clock), WithMetrics(metrics), WithMessageTTL(time.Minute, "/prices/**"), WithExpirySweep(0))
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer srv.Close()
+	clientID := srv.HandleMessage(&message.BayeuxMessage{Channel: "/meta/handshake"}).ClientID
+	srv.HandleMessage(&message.BayeuxMessage{Channel: "/meta/subscribe", ClientID: clientID, Subscription: "/**"})
+	publish := func(channel, ttl string) *message.BayeuxMessage {
+		msg := &message.BayeuxMessage{Channel: channel, ClientID: clientID, Data: message.MustData(channel)}
+		if ttl != "" {
+			msg.Ext = map[string]json.RawMessage{TTLExt: json.RawMessage(ttl)}
+		}
+		return srv.HandleMessage(msg)
+	}
+	publish("/prices/aapl", "")
+	publish("/chat", "")
+	publish("/chat", "10000")
+	publish("/prices/msft", "120000")
+	for _, ttl := range []string{"0", "-5", `"soon"`, "1.5"} {
+		if resp := publish("/chat", ttl); resp.Error != "400:/chat:Invalid ttl" {
+			t.Errorf("ttl %s: expected invalid ttl error, got %+v", ttl, resp)
+		}
+	}
+	waitForFanout(t, srv)
+
+	clock.Advance(time.Minute)
+	sess := srv.getSession(clientID)
+	if n := sess.DropExpired(); n != 2 {
+		t.Errorf("Expected 2 expired messages, got %d", n)
+	}
+	msgs := sess.DequeueAll()
+	if len(msgs) != 2 || string(msgs[0].Data) != `"/chat"` || string(msgs[1].Data) != `"/prices/msft"` {
+		t.Errorf("Expected only unexpired messages, got %d", len(msgs))
+	}
+	if n := metrics.counter(MetricMessagesExpired); n != 2 {
+		t.Errorf("Expected 2 expired messages counted, got %d", n)
+	}
+
+	ch, err := srv.Channel("/prices/aapl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ttl := ch.TTL(); ttl != time.Minute {
+		t.Errorf("Expected channel TTL of a minute, got %v", ttl)
+	}
+}
+
+func TestConflatingChannels(t *testing.T) {
+	srv, err := New(WithConflatingChannels("/prices/*"))
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer srv.Close()
+	clientID := srv.HandleMessage(&message.BayeuxMessage{Channel: "/meta/handshake"}).ClientID
+	srv.HandleMessage(&message.BayeuxMessage{Channel: "/meta/subscribe", ClientID: clientID, Subscription: "/prices/*"})
+	for i, symbol := range []string{"AAPL", "MSFT", "AAPL", "AAPL"} {
+		srv.HandleMessage(&message.BayeuxMessage{
+			Channel:  "/prices/nasdaq",
+			ClientID: clientID,
+			Data:     message.MustData(i),
+			Ext:      map[string]json.RawMessage{ConflationKeyExt: message.MustData(symbol)},
+		})
+	}
+	resp := srv.HandleMessage(&message.BayeuxMessage{
+		Channel:  "/prices/nasdaq",
+		ClientID: clientID,
+		Data:     message.MustData(4),
+		Ext:      map[string]json.RawMessage{ConflationKeyExt: json.RawMessage(`7`)},
+	})
+	if resp.Error != "400:/prices/nasdaq:Invalid conflation key" {
+		t.Errorf("Expected invalid conflation key error, got %+v", resp)
+	}
+	waitForFanout(t, srv)
+	msgs := srv.getSession(clientID).DequeueAll()
+	if len(msgs) != 2 || string(msgs[0].Data) != "3" || string(msgs[1].Data) != "1" {
+		t.Errorf("Expected the latest AAPL then MSFT price, got %d messages", len(msgs))
+	}
+}
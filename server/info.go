@@ -0,0 +1,139 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/charlinchui/galliard/internal/channel"
+	"github.com/charlinchui/galliard/internal/client"
+	"github.com/charlinchui/galliard/message"
+)
+
+// ErrInvalidChannel is returned for malformed channel names and for
+// channels that cannot be used, such as meta channels.
+var ErrInvalidChannel = errors.New("server: invalid channel")
+
+// ErrRejected is returned, wrapping the interceptor's error, when a
+// PublishInterceptor rejects a message published from Go code.
+var ErrRejected = errors.New("server: publish rejected")
+
+// SessionInfo is a snapshot of a client session, as reported by SessionList.
+type SessionInfo struct {
+	ID            string    `json:"id"`
+	Subscriptions []string  `json:"subscriptions"`
+	QueueDepth    int       `json:"queueDepth"`
+	LastSeen      time.Time `json:"lastSeen"`
+	Transport     string    `json:"transport,omitempty"`
+	Principal     string    `json:"principal,omitempty"`
+}
+
+// ChannelInfo is a snapshot of a channel, as reported by ChannelList.
+type ChannelInfo struct {
+	Name        string `json:"name"`
+	Subscribers int    `json:"subscribers"`
+}
+
+// SessionList returns a snapshot of all sessions, ordered by ID.
+func (s *Server) SessionList() []SessionInfo {
+	sessions := s.sessions.values()
+	infos := make([]SessionInfo, 0, len(sessions))
+	for _, sess := range sessions {
+		infos = append(infos, sessionInfo(sess))
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].ID < infos[j].ID })
+	return infos
+}
+
+// Session returns a snapshot of the session with the given client ID,
+// reporting false if there is none.
+func (s *Server) Session(clientID string) (SessionInfo, bool) {
+	sess := s.getSession(clientID)
+	if sess == nil {
+		return SessionInfo{}, false
+	}
+	return sessionInfo(sess), true
+}
+
+func sessionInfo(sess *client.Session) SessionInfo {
+	info := SessionInfo{
+		ID:            sess.ID,
+		Subscriptions: sess.SubscriptionList(),
+		QueueDepth:    sess.QueueLen(),
+		LastSeen:      sess.LastSeen(),
+		Transport:     sess.Transport(),
+	}
+	if p := sess.Principal(); p != nil {
+		info.Principal = p.Subject
+	}
+	return info
+}
+
+// SessionCount returns the number of active sessions.
+func (s *Server) SessionCount() int {
+	return s.sessions.len()
+}
+
+// ChannelCount returns the number of known channels.
+func (s *Server) ChannelCount() int {
+	return s.channels.len()
+}
+
+// ChannelList returns a snapshot of all channels, ordered by name.
+func (s *Server) ChannelList() []ChannelInfo {
+	channels := s.channels.values()
+	infos := make([]ChannelInfo, 0, len(channels))
+	for _, ch := range channels {
+		infos = append(infos, ChannelInfo{Name: ch.Name, Subscribers: ch.SubscriberCount()})
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name < infos[j].Name })
+	return infos
+}
+
+// ChannelSubscribers returns the IDs of the sessions subscribed to the named
+// channel, and false if the channel does not exist.
+func (s *Server) ChannelSubscribers(name string) ([]string, bool) {
+	ch, ok := s.getChannel(name)
+	if !ok {
+		return nil, false
+	}
+	return ch.SubscriberIDs(), true
+}
+
+// Disconnect removes a session as if the client had sent /meta/disconnect,
+// reporting whether the session existed.
+func (s *Server) Disconnect(clientID string) bool {
+	return s.removeSession(clientID)
+}
+
+// Publish delivers data to all subscribers of a channel on behalf of the server.
+// Meta channels cannot be published to.
+func (s *Server) Publish(channel string, data json.RawMessage) error {
+	return s.PublishMessage(&message.BayeuxMessage{Channel: channel, Data: data})
+}
+
+// PublishMessage is like Publish but takes a complete message, so that
+// fields such as Lazy can be set. Subscribers receive only its channel,
+// data and id, never its clientId or ext. If a PublishInterceptor rejects the
+// message, it returns ErrRejected wrapping the interceptor's error.
+func (s *Server) PublishMessage(msg *message.BayeuxMessage) error {
+	if !channel.Valid(msg.Channel) || channel.IsWildcard(msg.Channel) || strings.HasPrefix(msg.Channel, "/meta/") {
+		return ErrInvalidChannel
+	}
+	ch := s.getOrCreateChannel(msg.Channel)
+	if err := ch.Intercept(context.Background(), msg); err != nil {
+		return fmt.Errorf("%w: %w", ErrRejected, err)
+	}
+	s.publish(ch, msg)
+	return nil
+}
+
+// FanoutQueueDepth returns the number of published messages waiting to be,
+// or being, delivered to their subscribers.
+func (s *Server) FanoutQueueDepth() int {
+	return s.fanout.Len()
+}
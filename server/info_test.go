@@ -0,0 +1,79 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/charlinchui/galliard/message"
+)
+
+func TestSessionAndChannelList(t *testing.T) {
+	srv := NewServer()
+	defer srv.Close()
+	clientID := srv.HandleMessage(&message.BayeuxMessage{Channel: "/meta/handshake"}).ClientID
+	srv.HandleMessage(&message.BayeuxMessage{Channel: "/meta/subscribe", ClientID: clientID, Subscription: "/foo"})
+	srv.HandleMessage(&message.BayeuxMessage{Channel: "/meta/connect", ClientID: clientID, ConnectionType: "long-polling"})
+	srv.HandleMessage(&message.BayeuxMessage{Channel: "/foo", ClientID: clientID, Data: message.MustData(1)})
+	waitForFanout(t, srv)
+
+	sessions := srv.SessionList()
+	if len(sessions) != 1 {
+		t.Fatalf("Expected 1 session, got %d", len(sessions))
+	}
+	info := sessions[0]
+	if info.ID != clientID || info.QueueDepth != 1 || info.Transport != "long-polling" {
+		t.Errorf("Unexpected session info: %+v", info)
+	}
+	if len(info.Subscriptions) != 1 || info.Subscriptions[0] != "/foo" {
+		t.Errorf("Expected subscription to /foo, got %v", info.Subscriptions)
+	}
+	if info.LastSeen.IsZero() {
+		t.Errorf("Expected last seen to be recorded")
+	}
+
+	if one, ok := srv.Session(clientID); !ok || one.ID != clientID || one.QueueDepth != 1 {
+		t.Errorf("Unexpected session lookup: %+v", one)
+	}
+	if _, ok := srv.Session("missing"); ok {
+		t.Errorf("Expected unknown session to be reported")
+	}
+
+	channels := srv.ChannelList()
+	if len(channels) != 1 || channels[0].Name != "/foo" || channels[0].Subscribers != 1 {
+		t.Errorf("Unexpected channel list: %+v", channels)
+	}
+	subs, ok := srv.ChannelSubscribers("/foo")
+	if !ok || len(subs) != 1 || subs[0] != clientID {
+		t.Errorf("Unexpected subscribers: %v", subs)
+	}
+	if _, ok := srv.ChannelSubscribers("/missing"); ok {
+		t.Errorf("Expected unknown channel to be reported")
+	}
+}
+
+func TestServerDisconnectAndPublish(t *testing.T) {
+	srv := NewServer()
+	defer srv.Close()
+	clientID := srv.HandleMessage(&message.BayeuxMessage{Channel: "/meta/handshake"}).ClientID
+	srv.HandleMessage(&message.BayeuxMessage{Channel: "/meta/subscribe", ClientID: clientID, Subscription: "/news"})
+
+	if err := srv.Publish("/news", message.MustData("extra")); err != nil {
+		t.Fatalf("Publish failed: %v", err)
+	}
+	waitForFanout(t, srv)
+	if n := srv.getSession(clientID).QueueLen(); n != 1 {
+		t.Errorf("Expected 1 queued message, got %d", n)
+	}
+	if err := srv.Publish("/meta/connect", nil); err != ErrInvalidChannel {
+		t.Errorf("Expected ErrInvalidChannel, got %v", err)
+	}
+
+	if !srv.Disconnect(clientID) {
+		t.Fatalf("Expected session to be disconnected")
+	}
+	if srv.Disconnect(clientID) {
+		t.Errorf("Expected second disconnect to report unknown session")
+	}
+	if subs, _ := srv.ChannelSubscribers("/news"); len(subs) != 0 {
+		t.Errorf("Expected no subscribers after disconnect, got %v", subs)
+	}
+}
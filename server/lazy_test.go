@@ -0,0 +1,91 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/charlinchui/galliard/message"
+)
+
+func TestLazyChannelDelaysHeldConnect(t *testing.T) {
+	srv, err := New(WithLazyChannels("/ticks/**"), WithMaxLazy(100*time.Millisecond))
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer srv.Close()
+	clientID := handshakeAndConnect(t, srv, "", 5000)
+	srv.HandleMessage(&message.BayeuxMessage{Channel: "/meta/subscribe", ClientID: clientID, Subscription: "/ticks/acme"})
+
+	done := make(chan time.Time)
+	go func() {
+		srv.HandleMessage(&message.BayeuxMessage{Channel: "/meta/connect", ClientID: clientID})
+		done <- time.Now()
+	}()
+	time.Sleep(20 * time.Millisecond)
+	published := time.Now()
+	srv.HandleMessage(&message.BayeuxMessage{Channel: "/ticks/acme", ClientID: clientID, Data: message.MustData(1)})
+
+	select {
+	case returned := <-done:
+		if returned.Sub(published) < 80*time.Millisecond {
+			t.Errorf("Expected lazy message to be held, connect returned after %v", returned.Sub(published))
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Held connect was not woken by lazy timer")
+	}
+}
+
+func TestLazyMessageFlushedByNonLazy(t *testing.T) {
+	srv, err := New(WithMaxLazy(time.Hour))
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer srv.Close()
+	clientID := handshakeAndConnect(t, srv, "", 5000)
+	srv.HandleMessage(&message.BayeuxMessage{Channel: "/meta/subscribe", ClientID: clientID, Subscription: "/news"})
+
+	done := make(chan *message.BayeuxMessage)
+	go func() {
+		done <- srv.HandleMessage(&message.BayeuxMessage{Channel: "/meta/connect", ClientID: clientID})
+	}()
+	time.Sleep(20 * time.Millisecond)
+	if err := srv.PublishMessage(&message.BayeuxMessage{Channel: "/news", Data: message.MustData("lazy"), Lazy: true}); err != nil {
+		t.Fatalf("PublishMessage failed: %v", err)
+	}
+	select {
+	case <-done:
+		t.Fatal("Expected lazy message not to wake the held connect")
+	case <-time.After(50 * time.Millisecond):
+	}
+	srv.Publish("/news", message.MustData("urgent"))
+	select {
+	case resp := <-done:
+		if string(resp.Data) != `"lazy"` {
+			t.Errorf("Expected queued lazy message first, got %s", resp.Data)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Non-lazy message did not wake the held connect")
+	}
+}
+
+func TestLazyMessageQueuedBeforeConnectIsHeld(t *testing.T) {
+	srv, err := New(WithLazyChannels("/ticks/**"), WithMaxLazy(200*time.Millisecond))
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer srv.Close()
+	clientID := handshakeAndConnect(t, srv, "", 5000)
+	srv.HandleMessage(&message.BayeuxMessage{Channel: "/meta/subscribe", ClientID: clientID, Subscription: "/ticks/acme"})
+	published := time.Now()
+	srv.HandleMessage(&message.BayeuxMessage{Channel: "/ticks/acme", ClientID: clientID, Data: message.MustData(1)})
+	waitForFanout(t, srv)
+
+	srv.HandleMessage(&message.BayeuxMessage{Channel: "/ticks/acme", ClientID: clientID, Data: message.MustData(2)})
+	msgs := srv.HandleMessages([]*message.BayeuxMessage{{Channel: "/meta/connect", ClientID: clientID}})
+	if elapsed := time.Since(published); elapsed < 150*time.Millisecond {
+		t.Errorf("Expected connect to be held for the max-lazy window, returned after %v", elapsed)
+	}
+	if len(msgs) != 3 {
+		t.Errorf("Expected both lazy messages with the connect reply, got %d messages", len(msgs))
+	}
+}
@@ -0,0 +1,27 @@
+package server
+
+// Metrics receives counters and gauges describing server activity.
+// Implementations must be safe for concurrent use.
+type Metrics interface {
+	// IncCounter adds delta to the named counter.
+	IncCounter(name string, delta int64)
+	// SetGauge sets the named gauge to value.
+	SetGauge(name string, value int64)
+}
+
+// Metric names reported by the server.
+const (
+	MetricSessionsOpened    = "sessions.opened"
+	MetricSessionsClosed    = "sessions.closed"
+	MetricSessionsExpired   = "sessions.expired"
+	MetricSessionsActive    = "sessions.active"
+	MetricMessagesPublished = "messages.published"
+	MetricMessagesDropped   = "messages.dropped"
+	MetricMessagesExpired   = "messages.expired"
+	MetricRequestsDenied    = "requests.denied"
+)
+
+type noopMetrics struct{}
+
+func (noopMetrics) IncCounter(string, int64) {}
+func (noopMetrics) SetGauge(string, int64)   {}
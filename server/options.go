@@ -0,0 +1,306 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"runtime"
+	"time"
+
+	"github.com/charlinchui/galliard/internal/utils"
+	"github.com/charlinchui/galliard/message"
+)
+
+// Option configures a Server created by New.
+type Option func(*options)
+
+// Clock supplies the current time. It lets tests control session expiry.
+type Clock interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now() }
+
+type options struct {
+	advice                  message.Advice
+	sessionTimeout          time.Duration
+	maxQueue                int
+	maxConnectsPerBrowser   int
+	multipleClientsInterval time.Duration
+	newID                   func() string
+	logger                  *slog.Logger
+	clock                   Clock
+	metrics                 Metrics
+	policy                  Policy
+	authenticator           Authenticator
+	rateLimits              RateLimits
+	lazyChannels            []string
+	conflatingChannels      []string
+	retainedChannels        []string
+	store                   MessageStore
+	maxLazy                 time.Duration
+	fanoutWorkers           int
+	fanoutQueue             int
+	piggyback               bool
+	channelSweep            time.Duration
+	persistentChannels      []string
+	channelInitializers     []ChannelInitializer
+	channelListeners        []ChannelListener
+	messageTTLs             []messageTTL
+	expirySweep             time.Duration
+}
+
+// messageTTL is the TTL WithMessageTTL gives channels matching patterns.
+type messageTTL struct {
+	ttl      time.Duration
+	patterns []string
+}
+
+func defaultOptions() options {
+	return options{
+		advice: message.Advice{
+			Reconnect: "retry",
+			Interval:  0,
+			Timeout:   10000,
+		},
+		channelSweep:            time.Minute,
+		expirySweep:             time.Second,
+		fanoutWorkers:           runtime.GOMAXPROCS(0),
+		fanoutQueue:             1024,
+		maxConnectsPerBrowser:   1,
+		maxLazy:                 5 * time.Second,
+		multipleClientsInterval: 2 * time.Second,
+		newID:                   utils.GenerateID,
+		logger:                  slog.New(slog.NewTextHandler(io.Discard, nil)),
+		clock:                   systemClock{},
+		metrics:                 noopMetrics{},
+		policy:                  allowAll{},
+	}
+}
+
+// WithAdvice sets the advice sent to clients that do not negotiate their own.
+// Timeout is how long, in milliseconds, a /meta/connect is held.
+func WithAdvice(advice message.Advice) Option {
+	return func(o *options) { o.advice = advice }
+}
+
+// WithSessionTimeout expires sessions that have not been heard from for d.
+// It must exceed the advice timeout plus interval, or idle but healthy
+// long-polling clients would be expired. Zero, the default, never expires.
+func WithSessionTimeout(d time.Duration) Option {
+	return func(o *options) { o.sessionTimeout = d }
+}
+
+// WithMaxQueue bounds the number of undelivered messages kept per session.
+// When the queue is full the oldest message is dropped. Zero means unbounded.
+func WithMaxQueue(n int) Option {
+	return func(o *options) { o.maxQueue = n }
+}
+
+// WithMultipleClients sets how many connects a single browser may have held
+// at once, and the polling interval advised to its further clients.
+func WithMultipleClients(maxConnects int, interval time.Duration) Option {
+	return func(o *options) {
+		o.maxConnectsPerBrowser = maxConnects
+		o.multipleClientsInterval = interval
+	}
+}
+
+// WithIDGenerator sets the function used to generate client IDs. Empty IDs
+// and IDs of current sessions are discarded; a handshake fails with a 500
+// error if several attempts in a row yield no other.
+func WithIDGenerator(newID func() string) Option {
+	return func(o *options) { o.newID = newID }
+}
+
+// WithLogger sets the logger for session lifecycle events.
+// By default nothing is logged.
+func WithLogger(logger *slog.Logger) Option {
+	return func(o *options) { o.logger = logger }
+}
+
+// WithClock sets the clock used to track session activity.
+func WithClock(clock Clock) Option {
+	return func(o *options) { o.clock = clock }
+}
+
+// WithMetrics sets the sink for server metrics.
+func WithMetrics(metrics Metrics) Option {
+	return func(o *options) { o.metrics = metrics }
+}
+
+// WithPolicy sets the policy authorizing handshakes, subscriptions and
+// publishes. By default everything is allowed.
+func WithPolicy(policy Policy) Option {
+	return func(o *options) { o.policy = policy }
+}
+
+// WithAuthenticator verifies credentials during handshake and attaches the
+// resulting Principal to the session. By default every client is accepted
+// anonymously.
+func WithAuthenticator(a Authenticator) Option {
+	return func(o *options) { o.authenticator = a }
+}
+
+// WithRateLimits limits how fast sessions, principals and channels may
+// publish. Rejected publishes receive a 429 error.
+func WithRateLimits(limits RateLimits) Option {
+	return func(o *options) { o.rateLimits = limits }
+}
+
+// WithLazyChannels makes messages published to channels matching any of
+// patterns lazy: they are held in subscriber queues until a non-lazy message
+// arrives or the max-lazy interval passes, coalescing deliveries.
+// Patterns may end in "/*" or "/**".
+func WithLazyChannels(patterns ...string) Option {
+	return func(o *options) { o.lazyChannels = append(o.lazyChannels, patterns...) }
+}
+
+// WithConflatingChannels makes channels matching any of patterns
+// conflating: a subscriber's queue keeps only the newest message for each
+// conflation key, in the place of the first, so slow consumers catch up
+// with current values instead of every intermediate update. Publishers set
+// the key with the ConflationKeyExt field, or Go code with the message's
+// ConflationKey; messages without one are queued as usual.
+// Patterns may end in "/*" or "/**".
+func WithConflatingChannels(patterns ...string) Option {
+	return func(o *options) { o.conflatingChannels = append(o.conflatingChannels, patterns...) }
+}
+
+// WithRetainedChannels makes channels matching any of patterns keep the
+// last message published to them and deliver it to each new subscriber,
+// so clients immediately receive the current value. Only subscriptions to
+// the channel itself, not to wildcard patterns, receive it. A channel with
+// a retained message is never removed as unused; use
+// Channel.ClearRetained to clear it. Patterns may end in "/*" or "/**".
+func WithRetainedChannels(patterns ...string) Option {
+	return func(o *options) { o.retainedChannels = append(o.retainedChannels, patterns...) }
+}
+
+// WithMessageStore persists retained messages in store, restoring them
+// when their channel is next used.
+func WithMessageStore(store MessageStore) Option {
+	return func(o *options) { o.store = store }
+}
+
+// WithMaxLazy sets how long a lazy message may wait before it is delivered.
+// The default is 5 seconds.
+func WithMaxLazy(d time.Duration) Option {
+	return func(o *options) { o.maxLazy = d }
+}
+
+// WithFanout sets the number of workers delivering published messages to
+// subscribers and how many deliveries each may have queued. Messages on one
+// channel are always delivered by the same worker, in publish order.
+// Publishers block while their channel's worker queue is full.
+// The default is one worker per CPU with a queue of 1024.
+func WithFanout(workers, queueSize int) Option {
+	return func(o *options) {
+		o.fanoutWorkers = workers
+		o.fanoutQueue = queueSize
+	}
+}
+
+// WithPiggyback makes HandleMessages deliver a session's queued messages
+// with the replies to any batch it sends, such as a publish or subscribe,
+// instead of only with the replies to /meta/connect. This cuts latency for
+// clients that send often. Batches containing a /meta/connect are unaffected.
+func WithPiggyback(enabled bool) Option {
+	return func(o *options) { o.piggyback = enabled }
+}
+
+// WithChannelSweep sets how long a channel may go without subscribers or
+// activity before it is removed, unless it is persistent. Channels are
+// checked that often. The default is one minute; zero never removes them.
+func WithChannelSweep(d time.Duration) Option {
+	return func(o *options) { o.channelSweep = d }
+}
+
+// WithPersistentChannels keeps channels matching any of patterns even
+// while unused. Patterns may end in "/*" or "/**".
+func WithPersistentChannels(patterns ...string) Option {
+	return func(o *options) { o.persistentChannels = append(o.persistentChannels, patterns...) }
+}
+
+// WithChannelInitializer adds an initializer run on every new channel
+// before it is used. Initializers run in the order they were added.
+func WithChannelInitializer(init ChannelInitializer) Option {
+	return func(o *options) { o.channelInitializers = append(o.channelInitializers, init) }
+}
+
+// WithChannelListener adds a listener notified as channels are created
+// and removed.
+func WithChannelListener(l ChannelListener) Option {
+	return func(o *options) { o.channelListeners = append(o.channelListeners, l) }
+}
+
+// WithMessageTTL discards messages published to channels matching any of
+// patterns once they have waited ttl in a subscriber's queue, so clients
+// that reconnect late do not receive stale data. Publishers may set their
+// own TTL with the TTLExt field. When several calls match a channel, the
+// last one applies. Patterns may end in "/*" or "/**".
+func WithMessageTTL(ttl time.Duration, patterns ...string) Option {
+	return func(o *options) { o.messageTTLs = append(o.messageTTLs, messageTTL{ttl: ttl, patterns: patterns}) }
+}
+
+// WithExpirySweep sets how often session queues are checked for expired
+// messages. Expired messages are never delivered, but until checked they
+// count towards the max queue. The default is one second; zero only
+// checks when delivering.
+func WithExpirySweep(d time.Duration) Option {
+	return func(o *options) { o.expirySweep = d }
+}
+
+func (o *options) validate() error {
+	switch o.advice.Reconnect {
+	case "retry", "handshake", "none":
+	default:
+		return fmt.Errorf("server: invalid advice reconnect %q", o.advice.Reconnect)
+	}
+	if o.advice.Timeout < 0 || o.advice.Interval < 0 {
+		return errors.New("server: advice timeout and interval must not be negative")
+	}
+	if o.sessionTimeout < 0 {
+		return errors.New("server: session timeout must not be negative")
+	}
+	held := time.Duration(o.advice.Timeout+o.advice.Interval) * time.Millisecond
+	if o.sessionTimeout > 0 && o.sessionTimeout <= held {
+		return fmt.Errorf("server: session timeout %v must exceed advice timeout plus interval (%v)", o.sessionTimeout, held)
+	}
+	if o.maxQueue < 0 {
+		return errors.New("server: max queue must not be negative")
+	}
+	if o.maxConnectsPerBrowser < 1 {
+		return errors.New("server: max connects per browser must be at least 1")
+	}
+	if o.multipleClientsInterval < 0 {
+		return errors.New("server: multiple-clients interval must not be negative")
+	}
+	if o.maxLazy <= 0 {
+		return errors.New("server: max lazy interval must be positive")
+	}
+	if o.channelSweep < 0 {
+		return errors.New("server: channel sweep period must not be negative")
+	}
+	for _, t := range o.messageTTLs {
+		if t.ttl <= 0 {
+			return errors.New("server: message TTL must be positive")
+		}
+	}
+	if o.expirySweep < 0 {
+		return errors.New("server: expiry sweep period must not be negative")
+	}
+	if o.fanoutWorkers < 1 || o.fanoutQueue < 1 {
+		return errors.New("server: fan-out workers and queue size must be at least 1")
+	}
+	if err := o.rateLimits.validate(); err != nil {
+		return err
+	}
+	if o.newID == nil || o.logger == nil || o.clock == nil || o.metrics == nil || o.policy == nil {
+		return errors.New("server: ID generator, logger, clock, metrics and policy must not be nil")
+	}
+	return nil
+}
@@ -0,0 +1,170 @@
+package server
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/charlinchui/galliard/message"
+)
+
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+type recordingMetrics struct {
+	mu       sync.Mutex
+	counters map[string]int64
+	gauges   map[string]int64
+}
+
+func newRecordingMetrics() *recordingMetrics {
+	return &recordingMetrics{counters: map[string]int64{}, gauges: map[string]int64{}}
+}
+
+func (m *recordingMetrics) IncCounter(name string, delta int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.counters[name] += delta
+}
+
+func (m *recordingMetrics) SetGauge(name string, value int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.gauges[name] = value
+}
+
+func (m *recordingMetrics) counter(name string) int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.counters[name]
+}
+
+func TestNewValidation(t *testing.T) {
+	cases := map[string][]Option{
+		"reconnect":       {WithAdvice(message.Advice{Reconnect: "sometimes"})},
+		"negative":        {WithAdvice(message.Advice{Reconnect: "retry", Timeout: -1})},
+		"session timeout": {WithAdvice(message.Advice{Reconnect: "retry", Timeout: 30000}), WithSessionTimeout(20 * time.Second)},
+		"max queue":       {WithMaxQueue(-1)},
+		"browser":         {WithMultipleClients(0, time.Second)},
+		"max lazy":        {WithMaxLazy(0)},
+		"fanout":          {WithFanout(0, 16)},
+		"message ttl":     {WithMessageTTL(0, "/prices")},
+		"expiry sweep":    {WithExpirySweep(-time.Second)},
+		"id generator":    {WithIDGenerator(nil)},
+		"clock":           {WithClock(nil)},
+	}
+	for name, opts := range cases {
+		if _, err := New(opts...); err == nil {
+			t.Errorf("%s: expected validation error", name)
+		}
+	}
+}
+
+func TestWithAdviceAndIDGenerator(t *testing.T) {
+	ids := []string{"dup", "dup", "second"}
+	srv, err := New(
+		WithAdvice(message.Advice{Reconnect: "retry", Interval: 500, Timeout: 25000}),
+		WithIDGenerator(func() string {
+			id := ids[0]
+			ids = ids[1:]
+			return id
+		}),
+	)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer srv.Close()
+	resp := srv.HandleMessage(&message.BayeuxMessage{Channel: "/meta/handshake"})
+	if resp.ClientID != "dup" {
+		t.Errorf("Expected generated ID 'dup', got %q", resp.ClientID)
+	}
+	if resp.Advice.Timeout != 25000 || resp.Advice.Interval != 500 {
+		t.Errorf("Expected configured advice, got %+v", resp.Advice)
+	}
+	resp = srv.HandleMessage(&message.BayeuxMessage{Channel: "/meta/handshake"})
+	if resp.ClientID != "second" {
+		t.Errorf("Expected duplicate ID to be skipped, got %q", resp.ClientID)
+	}
+}
+
+func TestIDGeneratorExhausted(t *testing.T) {
+	srv, err := New(WithIDGenerator(func() string { return "" }))
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer srv.Close()
+	resp := srv.HandleMessage(&message.BayeuxMessage{Channel: "/meta/handshake", ID: "h1"})
+	if resp.Successful == nil || *resp.Successful || resp.Error != "500::Could not assign a client ID" || resp.ID != "h1" {
+		t.Errorf("Expected failed handshake, got %+v", resp)
+	}
+	if n := srv.SessionCount(); n != 0 {
+		t.Errorf("Expected no session to be created, got %d", n)
+	}
+}
+
+func TestSessionExpiry(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	metrics := newRecordingMetrics()
+	srv, err := New(WithClock(clock), WithMetrics(metrics), WithSessionTimeout(time.Minute))
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer srv.Close()
+
+	stale := srv.HandleMessage(&message.BayeuxMessage{Channel: "/meta/handshake"}).ClientID
+	clock.Advance(45 * time.Second)
+	fresh := srv.HandleMessage(&message.BayeuxMessage{Channel: "/meta/handshake"}).ClientID
+	clock.Advance(30 * time.Second)
+	srv.expireSessions()
+
+	if srv.getSession(stale) != nil {
+		t.Errorf("Expected stale session to expire")
+	}
+	if srv.getSession(fresh) == nil {
+		t.Errorf("Expected fresh session to be kept")
+	}
+	if n := metrics.counter(MetricSessionsExpired); n != 1 {
+		t.Errorf("Expected 1 expired session, got %d", n)
+	}
+	if n := metrics.counter(MetricSessionsOpened); n != 2 {
+		t.Errorf("Expected 2 opened sessions, got %d", n)
+	}
+}
+
+func TestMaxQueueOption(t *testing.T) {
+	metrics := newRecordingMetrics()
+	srv, err := New(WithMaxQueue(2), WithMetrics(metrics))
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer srv.Close()
+	clientID := srv.HandleMessage(&message.BayeuxMessage{Channel: "/meta/handshake"}).ClientID
+	srv.HandleMessage(&message.BayeuxMessage{Channel: "/meta/subscribe", ClientID: clientID, Subscription: "/foo"})
+	for i := 0; i < 5; i++ {
+		srv.HandleMessage(&message.BayeuxMessage{Channel: "/foo", ClientID: clientID, Data: message.MustData(i)})
+	}
+	waitForFanout(t, srv)
+	if n := srv.getSession(clientID).QueueLen(); n != 2 {
+		t.Errorf("Expected queue to be capped at 2, got %d", n)
+	}
+	if n := metrics.counter(MetricMessagesDropped); n != 3 {
+		t.Errorf("Expected 3 dropped messages, got %d", n)
+	}
+	if n := metrics.counter(MetricMessagesPublished); n != 5 {
+		t.Errorf("Expected 5 published messages, got %d", n)
+	}
+}
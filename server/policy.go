@@ -0,0 +1,98 @@
+package server
+
+import (
+	"context"
+
+	"github.com/charlinchui/galliard/internal/channel"
+	"github.com/charlinchui/galliard/message"
+)
+
+// Policy authorizes client operations. A denied handshake, subscribe or
+// publish receives an unsuccessful reply with a 403 error.
+// The context carries the transport Request, if any; see RequestFromContext.
+type Policy interface {
+	// CanHandshake reports whether a new session may be created.
+	CanHandshake(ctx context.Context, msg *message.BayeuxMessage) bool
+	// CanSubscribe reports whether clientID may subscribe to channel.
+	// The channel may be a wildcard pattern such as "/**", whose
+	// subscribers receive messages published to every channel it matches;
+	// implementations must only allow it if all of those may be received.
+	CanSubscribe(ctx context.Context, clientID, channel string, msg *message.BayeuxMessage) bool
+	// CanPublish reports whether clientID may publish to channel.
+	CanPublish(ctx context.Context, clientID, channel string, msg *message.BayeuxMessage) bool
+}
+
+// ChannelRule grants or denies operations on channels matching Pattern.
+// Patterns may end in "/*" or "/**" as in Bayeux wildcard subscriptions.
+type ChannelRule struct {
+	Pattern   string
+	Subscribe bool
+	Publish   bool
+}
+
+// RulePolicy is a Policy built from channel rules. The first rule whose
+// pattern matches a channel decides; channels matching no rule are allowed.
+// A wildcard subscription is allowed only if every channel under it is,
+// so that "/**" cannot be used to receive messages from denied channels.
+// Handshakes are always allowed.
+type RulePolicy []ChannelRule
+
+func (p RulePolicy) CanHandshake(context.Context, *message.BayeuxMessage) bool { return true }
+
+func (p RulePolicy) CanSubscribe(_ context.Context, _, ch string, _ *message.BayeuxMessage) bool {
+	if !channel.IsWildcard(ch) {
+		rule, ok := p.match(ch)
+		return !ok || rule.Subscribe
+	}
+	for i, rule := range p {
+		if rule.Subscribe {
+			continue
+		}
+		// The channels under ch that the rule denies, unless an earlier
+		// rule matches all of them and decides instead.
+		denied, ok := channel.Intersection(rule.Pattern, ch)
+		if ok && !p[:i].covers(denied) {
+			return false
+		}
+	}
+	return true
+}
+
+func (p RulePolicy) CanPublish(_ context.Context, _, ch string, _ *message.BayeuxMessage) bool {
+	if rule, ok := p.match(ch); ok {
+		return rule.Publish
+	}
+	return true
+}
+
+func (p RulePolicy) match(name string) (ChannelRule, bool) {
+	for _, rule := range p {
+		if channel.Match(rule.Pattern, name) {
+			return rule, true
+		}
+	}
+	return ChannelRule{}, false
+}
+
+// covers reports whether some rule matches every channel that pattern
+// matches.
+func (p RulePolicy) covers(pattern string) bool {
+	for _, rule := range p {
+		if both, ok := channel.Intersection(rule.Pattern, pattern); ok && both == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+type allowAll struct{}
+
+func (allowAll) CanHandshake(context.Context, *message.BayeuxMessage) bool { return true }
+
+func (allowAll) CanSubscribe(context.Context, string, string, *message.BayeuxMessage) bool {
+	return true
+}
+
+func (allowAll) CanPublish(context.Context, string, string, *message.BayeuxMessage) bool {
+	return true
+}
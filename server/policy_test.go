@@ -0,0 +1,114 @@
+package server
+
+import (
+	"context"
+	"testing"
+
+	"github.com/charlinchui/galliard/message"
+)
+
+type denyHandshakes struct{ allowAll }
+
+func (denyHandshakes) CanHandshake(context.Context, *message.BayeuxMessage) bool { return false }
+
+func TestPolicyDeniesHandshake(t *testing.T) {
+	srv, err := New(WithPolicy(denyHandshakes{}))
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer srv.Close()
+	resp := srv.HandleMessage(&message.BayeuxMessage{Channel: "/meta/handshake", ID: "h1"})
+	if resp.Successful == nil || *resp.Successful || resp.ClientID != "" {
+		t.Fatalf("Expected denied handshake, got %+v", resp)
+	}
+	if resp.Error != "403::Handshake denied" || resp.ID != "h1" {
+		t.Errorf("Unexpected denial reply: %+v", resp)
+	}
+	if srv.SessionCount() != 0 {
+		t.Errorf("Expected no session to be created")
+	}
+}
+
+func TestRulePolicy(t *testing.T) {
+	policy := RulePolicy{
+		{Pattern: "/admin/**"},
+		{Pattern: "/news/*", Subscribe: true},
+	}
+	srv, err := New(WithPolicy(policy))
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer srv.Close()
+	clientID := srv.HandleMessage(&message.BayeuxMessage{Channel: "/meta/handshake"}).ClientID
+
+	sub := func(ch string) *message.BayeuxMessage {
+		return srv.HandleMessage(&message.BayeuxMessage{Channel: "/meta/subscribe", ClientID: clientID, Subscription: ch})
+	}
+	pub := func(ch string) *message.BayeuxMessage {
+		return srv.HandleMessage(&message.BayeuxMessage{Channel: ch, ClientID: clientID, Data: message.MustData(1)})
+	}
+
+	if resp := sub("/admin/users"); *resp.Successful || resp.Error != "403:/admin/users:Subscription denied" {
+		t.Errorf("Expected subscription to /admin/users to be denied, got %+v", resp)
+	}
+	if resp := sub("/news/sports"); !*resp.Successful {
+		t.Errorf("Expected subscription to /news/sports to be allowed, got %+v", resp)
+	}
+	if resp := pub("/news/sports"); *resp.Successful || resp.Error != "403:/news/sports:Publish denied" {
+		t.Errorf("Expected publish to /news/sports to be denied, got %+v", resp)
+	}
+	if resp := pub("/chat"); !*resp.Successful {
+		t.Errorf("Expected publish to unmatched channel to be allowed, got %+v", resp)
+	}
+}
+
+func TestRulePolicyDeniesWildcardsOverDeniedChannels(t *testing.T) {
+	policy := RulePolicy{{Pattern: "/internal/**", Subscribe: false}}
+	srv, err := New(WithPolicy(policy))
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer srv.Close()
+	clientID := srv.HandleMessage(&message.BayeuxMessage{Channel: "/meta/handshake"}).ClientID
+	for _, sub := range []string{"/**", "/internal/*"} {
+		resp := srv.HandleMessage(&message.BayeuxMessage{Channel: "/meta/subscribe", ClientID: clientID, Subscription: sub})
+		if *resp.Successful || resp.Error != "403:"+sub+":Subscription denied" {
+			t.Errorf("Expected subscription to %s to be denied, got %+v", sub, resp)
+		}
+	}
+	if resp := srv.HandleMessage(&message.BayeuxMessage{Channel: "/meta/subscribe", ClientID: clientID, Subscription: "/*"}); !*resp.Successful {
+		t.Errorf("Expected subscription to /* to be allowed, got %+v", resp)
+	}
+	srv.HandleMessage(&message.BayeuxMessage{Channel: "/internal/secret", ClientID: clientID, Data: message.MustData(1)})
+	waitForFanout(t, srv)
+	if n := srv.getSession(clientID).QueueLen(); n != 0 {
+		t.Errorf("Expected nothing from /internal to be delivered, got %d messages", n)
+	}
+}
+
+func TestRulePolicyWildcardsFollowRuleOrder(t *testing.T) {
+	policy := RulePolicy{
+		{Pattern: "/public/**", Subscribe: true},
+		{Pattern: "/**", Subscribe: false},
+	}
+	srv, err := New(WithPolicy(policy))
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer srv.Close()
+	clientID := srv.HandleMessage(&message.BayeuxMessage{Channel: "/meta/handshake"}).ClientID
+	for sub, allowed := range map[string]bool{
+		"/public/a":   true,
+		"/public/*":   true,
+		"/public/**":  true,
+		"/public/a/*": true,
+		"/*":          false,
+		"/**":         false,
+		"/private/*":  false,
+	} {
+		resp := srv.HandleMessage(&message.BayeuxMessage{Channel: "/meta/subscribe", ClientID: clientID, Subscription: sub})
+		if *resp.Successful != allowed {
+			t.Errorf("Expected subscription to %s allowed=%v, got %+v", sub, allowed, resp)
+		}
+	}
+}
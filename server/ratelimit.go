@@ -0,0 +1,194 @@
+package server
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/charlinchui/galliard/internal/channel"
+	"github.com/charlinchui/galliard/internal/ratelimit"
+)
+
+// Metric names reported for rate limiting.
+const (
+	MetricRateLimitedSession   = "ratelimit.session.rejected"
+	MetricRateLimitedPrincipal = "ratelimit.principal.rejected"
+	MetricRateLimitedChannel   = "ratelimit.channel.rejected"
+)
+
+// RateLimit allows Rate publishes per second with bursts of up to Burst.
+// A zero Rate disables the limit.
+type RateLimit struct {
+	Rate  float64
+	Burst int
+}
+
+// ChannelRateLimit applies a RateLimit to every channel matching Pattern.
+// Each matching channel has its own budget, shared by all its publishers.
+type ChannelRateLimit struct {
+	Pattern string
+	RateLimit
+}
+
+// RateLimits configures token-bucket limits on publishing.
+type RateLimits struct {
+	// Session limits each session.
+	Session RateLimit
+	// Principal limits each authenticated principal across all of its sessions.
+	Principal RateLimit
+	// Channels limits publishing to channels; the first matching pattern applies.
+	Channels []ChannelRateLimit
+	// ThrottleInterval is the advice interval sent on the next /meta/connect
+	// of a session that had a publish rejected, slowing the client down.
+	ThrottleInterval time.Duration
+}
+
+// RateLimitStats counts publishes rejected by each kind of rate limit.
+type RateLimitStats struct {
+	Session   int64 `json:"session"`
+	Principal int64 `json:"principal"`
+	Channel   int64 `json:"channel"`
+}
+
+func (r RateLimit) validate() error {
+	if r.Rate < 0 || r.Burst < 0 || (r.Rate > 0 && r.Burst < 1) {
+		return errors.New("server: rate limits need a non-negative rate and a burst of at least 1")
+	}
+	return nil
+}
+
+func (l RateLimits) validate() error {
+	for _, r := range append([]RateLimit{l.Session, l.Principal}, channelLimits(l.Channels)...) {
+		if err := r.validate(); err != nil {
+			return err
+		}
+	}
+	if l.ThrottleInterval < 0 {
+		return errors.New("server: throttle interval must not be negative")
+	}
+	return nil
+}
+
+func channelLimits(limits []ChannelRateLimit) []RateLimit {
+	out := make([]RateLimit, len(limits))
+	for i, l := range limits {
+		out[i] = l.RateLimit
+	}
+	return out
+}
+
+// pruneEvery is how many buckets a bucketSet creates between sweeps for
+// buckets that have refilled completely and can be forgotten.
+const pruneEvery = 1024
+
+type bucketSet struct {
+	mu      sync.Mutex
+	buckets map[string]*ratelimit.Bucket
+	created int
+}
+
+// get returns the bucket for key, creating a full one for limit if needed.
+func (bs *bucketSet) get(key string, limit RateLimit, now time.Time) *ratelimit.Bucket {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	b, ok := bs.buckets[key]
+	if !ok {
+		if bs.buckets == nil {
+			bs.buckets = make(map[string]*ratelimit.Bucket)
+		}
+		b = ratelimit.NewBucket(limit.Rate, limit.Burst, now)
+		bs.buckets[key] = b
+		if bs.created++; bs.created%pruneEvery == 0 {
+			for k, old := range bs.buckets {
+				if old != b && old.Full(now) {
+					delete(bs.buckets, k)
+				}
+			}
+		}
+	}
+	return b
+}
+
+func (bs *bucketSet) forget(key string) {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	delete(bs.buckets, key)
+}
+
+// rateLimiter enforces RateLimits and remembers which sessions to throttle.
+type rateLimiter struct {
+	limits     RateLimits
+	sessions   bucketSet
+	principals bucketSet
+	channels   bucketSet
+	throttled  sync.Map
+	stats      struct{ session, principal, channel atomic.Int64 }
+}
+
+// allow reports whether a publish is within limits. On rejection it returns
+// the metric name of the limit that was hit. A rejected publish takes no
+// token from any limit, so it does not use up the budget of the others.
+func (rl *rateLimiter) allow(clientID string, principal *Principal, ch string, now time.Time) (bool, string) {
+	var (
+		buckets []*ratelimit.Bucket
+		limits  []string
+	)
+	if rl.limits.Session.Rate > 0 {
+		buckets = append(buckets, rl.sessions.get(clientID, rl.limits.Session, now))
+		limits = append(limits, MetricRateLimitedSession)
+	}
+	if rl.limits.Principal.Rate > 0 && principal != nil && principal.Subject != "" {
+		buckets = append(buckets, rl.principals.get(principal.Subject, rl.limits.Principal, now))
+		limits = append(limits, MetricRateLimitedPrincipal)
+	}
+	for _, cl := range rl.limits.Channels {
+		if !channel.Match(cl.Pattern, ch) {
+			continue
+		}
+		if cl.Rate > 0 {
+			buckets = append(buckets, rl.channels.get(ch, cl.RateLimit, now))
+			limits = append(limits, MetricRateLimitedChannel)
+		}
+		break
+	}
+	i := ratelimit.AllowAll(now, buckets...)
+	if i < 0 {
+		return true, ""
+	}
+	switch limits[i] {
+	case MetricRateLimitedSession:
+		rl.stats.session.Add(1)
+	case MetricRateLimitedPrincipal:
+		rl.stats.principal.Add(1)
+	case MetricRateLimitedChannel:
+		rl.stats.channel.Add(1)
+	}
+	return rl.reject(clientID, limits[i])
+}
+
+func (rl *rateLimiter) reject(clientID, metric string) (bool, string) {
+	rl.throttled.Store(clientID, struct{}{})
+	return false, metric
+}
+
+// takeThrottled reports whether the session was rate limited since the last
+// call, clearing the mark.
+func (rl *rateLimiter) takeThrottled(clientID string) bool {
+	_, ok := rl.throttled.LoadAndDelete(clientID)
+	return ok
+}
+
+func (rl *rateLimiter) forget(clientID string) {
+	rl.sessions.forget(clientID)
+	rl.throttled.Delete(clientID)
+}
+
+// RateLimitStats returns how many publishes each kind of limit has rejected.
+func (s *Server) RateLimitStats() RateLimitStats {
+	return RateLimitStats{
+		Session:   s.limiter.stats.session.Load(),
+		Principal: s.limiter.stats.principal.Load(),
+		Channel:   s.limiter.stats.channel.Load(),
+	}
+}
@@ -0,0 +1,129 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/charlinchui/galliard/message"
+)
+
+func publishN(srv *Server, clientID, ch string, n int) (ok, limited int) {
+	for i := 0; i < n; i++ {
+		resp := srv.HandleMessage(&message.BayeuxMessage{Channel: ch, ClientID: clientID, Data: message.MustData(i)})
+		if *resp.Successful {
+			ok++
+		} else if resp.Error == "429:"+ch+":Rate limit exceeded" {
+			limited++
+		}
+	}
+	return ok, limited
+}
+
+func TestSessionRateLimit(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	metrics := newRecordingMetrics()
+	srv, err := New(WithClock(clock), WithMetrics(metrics), WithRateLimits(RateLimits{
+		Session:          RateLimit{Rate: 1, Burst: 3},
+		ThrottleInterval: 5 * time.Second,
+	}))
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer srv.Close()
+	a := srv.HandleMessage(&message.BayeuxMessage{Channel: "/meta/handshake"}).ClientID
+	b := srv.HandleMessage(&message.BayeuxMessage{Channel: "/meta/handshake"}).ClientID
+
+	if ok, limited := publishN(srv, a, "/foo", 5); ok != 3 || limited != 2 {
+		t.Errorf("Expected 3 allowed and 2 limited, got %d and %d", ok, limited)
+	}
+	if ok, _ := publishN(srv, b, "/foo", 3); ok != 3 {
+		t.Errorf("Expected other session to have its own budget, got %d allowed", ok)
+	}
+	clock.Advance(time.Second)
+	if ok, _ := publishN(srv, a, "/foo", 2); ok != 1 {
+		t.Errorf("Expected one token after refill, got %d allowed", ok)
+	}
+
+	resp := srv.HandleMessage(&message.BayeuxMessage{Channel: "/meta/connect", ClientID: a, Advice: &message.Advice{Timeout: 0}})
+	if resp.Advice == nil || resp.Advice.Interval != 5000 {
+		t.Errorf("Expected throttled interval 5000, got %+v", resp.Advice)
+	}
+	resp = srv.HandleMessage(&message.BayeuxMessage{Channel: "/meta/connect", ClientID: a, Advice: &message.Advice{Timeout: 0}})
+	if resp.Advice.Interval != 0 {
+		t.Errorf("Expected interval to return to normal, got %d", resp.Advice.Interval)
+	}
+
+	if stats := srv.RateLimitStats(); stats.Session != 3 {
+		t.Errorf("Expected 3 session rejections, got %+v", stats)
+	}
+	if n := metrics.counter(MetricRateLimitedSession); n != 3 {
+		t.Errorf("Expected 3 rejections in metrics, got %d", n)
+	}
+}
+
+func TestPrincipalAndChannelRateLimits(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	auth := AuthenticatorFunc(func(context.Context, *message.BayeuxMessage) (*Principal, error) {
+		return &Principal{Subject: "carol"}, nil
+	})
+	srv, err := New(WithClock(clock), WithAuthenticator(auth), WithRateLimits(RateLimits{
+		Principal: RateLimit{Rate: 1, Burst: 4},
+		Channels: []ChannelRateLimit{
+			{Pattern: "/open/**"},
+			{Pattern: "/**", RateLimit: RateLimit{Rate: 1, Burst: 2}},
+		},
+	}))
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer srv.Close()
+	a := srv.HandleMessage(&message.BayeuxMessage{Channel: "/meta/handshake"}).ClientID
+	b := srv.HandleMessage(&message.BayeuxMessage{Channel: "/meta/handshake"}).ClientID
+
+	if ok, _ := publishN(srv, a, "/room/1", 3); ok != 2 {
+		t.Errorf("Expected channel burst of 2, got %d allowed", ok)
+	}
+	if ok, _ := publishN(srv, b, "/room/2", 3); ok != 2 {
+		t.Errorf("Expected second channel to have its own budget, got %d allowed", ok)
+	}
+	if ok, _ := publishN(srv, b, "/open/chat", 3); ok != 0 {
+		t.Errorf("Expected principal budget to be shared across sessions, got %d allowed", ok)
+	}
+	stats := srv.RateLimitStats()
+	if stats.Channel != 1 || stats.Principal != 4 {
+		t.Errorf("Unexpected stats: %+v", stats)
+	}
+}
+
+func TestRejectedPublishKeepsSessionBudget(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	srv, err := New(WithClock(clock), WithRateLimits(RateLimits{
+		Session:  RateLimit{Rate: 1, Burst: 2},
+		Channels: []ChannelRateLimit{{Pattern: "/busy", RateLimit: RateLimit{Rate: 1, Burst: 1}}},
+	}))
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer srv.Close()
+	clientID := srv.HandleMessage(&message.BayeuxMessage{Channel: "/meta/handshake"}).ClientID
+
+	if ok, limited := publishN(srv, clientID, "/busy", 3); ok != 1 || limited != 2 {
+		t.Errorf("Expected 1 allowed and 2 limited by the channel, got %d and %d", ok, limited)
+	}
+	if ok, _ := publishN(srv, clientID, "/quiet", 2); ok != 1 {
+		t.Errorf("Expected the session to keep the token the channel limit refused, got %d allowed", ok)
+	}
+	if stats := srv.RateLimitStats(); stats.Channel != 2 || stats.Session != 1 {
+		t.Errorf("Unexpected stats: %+v", stats)
+	}
+}
+
+func TestRateLimitValidation(t *testing.T) {
+	if _, err := New(WithRateLimits(RateLimits{Session: RateLimit{Rate: 1}})); err == nil {
+		t.Errorf("Expected error for zero burst")
+	}
+	if _, err := New(WithRateLimits(RateLimits{Channels: []ChannelRateLimit{{Pattern: "/x", RateLimit: RateLimit{Rate: -1, Burst: 1}}}})); err == nil {
+		t.Errorf("Expected error for negative rate")
+	}
+}
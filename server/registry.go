@@ -0,0 +1,121 @@
+package server
+
+import (
+	"hash/fnv"
+	"sync"
+	"sync/atomic"
+)
+
+// registryShards is the number of independently locked shards in a
+// registry. It is a power of two so shard selection is a mask.
+const registryShards = 64
+
+// registry is a concurrent map from session IDs or channel names to values,
+// split into shards so that unrelated keys do not contend on one lock.
+//
+// Shard locks are leaves: no other lock is acquired while one is held, and
+// callbacks such as deleteIf's match must not call back into the server.
+// Operations spanning several keys, like snapshots, lock one shard at a time
+// and so are not atomic across shards.
+type registry[V any] struct {
+	shards [registryShards]registryShard[V]
+	n      atomic.Int64
+}
+
+type registryShard[V any] struct {
+	mu sync.RWMutex
+	m  map[string]V
+}
+
+func newRegistry[V any]() *registry[V] {
+	r := &registry[V]{}
+	for i := range r.shards {
+		r.shards[i].m = make(map[string]V)
+	}
+	return r
+}
+
+func (r *registry[V]) shard(key string) *registryShard[V] {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return &r.shards[h.Sum32()&(registryShards-1)]
+}
+
+func (r *registry[V]) get(key string) (V, bool) {
+	sh := r.shard(key)
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+	v, ok := sh.m[key]
+	return v, ok
+}
+
+// put stores v under key, replacing any existing value.
+func (r *registry[V]) put(key string, v V) {
+	sh := r.shard(key)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	if _, exists := sh.m[key]; !exists {
+		r.n.Add(1)
+	}
+	sh.m[key] = v
+}
+
+// putIfAbsent stores v under key unless a value is already stored there.
+// It returns the value now stored and whether it is v.
+func (r *registry[V]) putIfAbsent(key string, v V) (V, bool) {
+	sh := r.shard(key)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	if existing, ok := sh.m[key]; ok {
+		return existing, false
+	}
+	sh.m[key] = v
+	r.n.Add(1)
+	return v, true
+}
+
+// delete removes key and returns the value it held, if any.
+func (r *registry[V]) delete(key string) (V, bool) {
+	sh := r.shard(key)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	v, ok := sh.m[key]
+	if ok {
+		delete(sh.m, key)
+		r.n.Add(-1)
+	}
+	return v, ok
+}
+
+// deleteIf removes key if match reports true for the value it holds,
+// reporting whether it did. match runs with the shard locked.
+func (r *registry[V]) deleteIf(key string, match func(V) bool) bool {
+	sh := r.shard(key)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	v, ok := sh.m[key]
+	if !ok || !match(v) {
+		return false
+	}
+	delete(sh.m, key)
+	r.n.Add(-1)
+	return true
+}
+
+func (r *registry[V]) len() int {
+	return int(r.n.Load())
+}
+
+// values returns a snapshot of the stored values in no particular order.
+func (r *registry[V]) values() []V {
+	vs := make([]V, 0, r.len())
+	for i := range r.shards {
+		sh := &r.shards[i]
+		sh.mu.RLock()
+		for _, v := range sh.m {
+			vs = append(vs, v)
+		}
+		sh.mu.RUnlock()
+	}
+	return vs
+}
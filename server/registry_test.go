@@ -0,0 +1,60 @@
+package server
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestRegistry(t *testing.T) {
+	r := newRegistry[int]()
+	r.put("a", 1)
+	r.put("a", 2)
+	if v, ok := r.get("a"); !ok || v != 2 {
+		t.Errorf("Expected a=2, got %d, %v", v, ok)
+	}
+	if n := r.len(); n != 1 {
+		t.Errorf("Expected 1 entry, got %d", n)
+	}
+	if v, stored := r.putIfAbsent("a", 3); stored || v != 2 {
+		t.Errorf("Expected existing value 2, got %d, %v", v, stored)
+	}
+	if v, stored := r.putIfAbsent("b", 3); !stored || v != 3 {
+		t.Errorf("Expected stored value 3, got %d, %v", v, stored)
+	}
+	if v, ok := r.delete("a"); !ok || v != 2 {
+		t.Errorf("Expected to delete a=2, got %d, %v", v, ok)
+	}
+	if _, ok := r.delete("a"); ok {
+		t.Errorf("Expected second delete to report nothing removed")
+	}
+	if r.deleteIf("b", func(v int) bool { return v != 3 }) {
+		t.Errorf("Expected deleteIf to keep a non-matching value")
+	}
+	if vs := r.values(); len(vs) != 1 || vs[0] != 3 || r.len() != 1 {
+		t.Errorf("Expected only b=3 to remain, got %v", vs)
+	}
+	if !r.deleteIf("b", func(v int) bool { return v == 3 }) || r.len() != 0 {
+		t.Errorf("Expected deleteIf to remove a matching value")
+	}
+}
+
+func TestRegistryPutIfAbsentOnce(t *testing.T) {
+	r := newRegistry[int]()
+	var stored atomic.Int64
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if _, ok := r.putIfAbsent(fmt.Sprintf("k%d", i%10), i); ok {
+				stored.Add(1)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if n := r.len(); n != 10 || stored.Load() != 10 {
+		t.Errorf("Expected 10 entries stored once each, got %d entries and %d stores", n, stored.Load())
+	}
+}
@@ -0,0 +1,48 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http"
+)
+
+// Request describes the transport request a message arrived on.
+// Transports attach it to the context passed to HandleMessageContext,
+// where policies and hooks can retrieve it with RequestFromContext.
+type Request struct {
+	// Transport names the connection type, e.g. "long-polling".
+	Transport string
+
+	// RemoteAddr is the network address of the client.
+	RemoteAddr string
+
+	// Header holds the request headers, including cookies.
+	Header http.Header
+
+	// TLS describes the TLS connection, including peer certificates,
+	// or is nil for plain-text connections.
+	TLS *tls.ConnectionState
+
+	// BrowserID identifies the browser the request came from, so that the
+	// server can limit held connects per browser. Empty disables the limit.
+	BrowserID string
+}
+
+// Cookie returns the named cookie sent with the request.
+func (r *Request) Cookie(name string) (*http.Cookie, error) {
+	req := http.Request{Header: r.Header}
+	return req.Cookie(name)
+}
+
+type requestKey struct{}
+
+// WithRequest returns a copy of ctx carrying req.
+func WithRequest(ctx context.Context, req *Request) context.Context {
+	return context.WithValue(ctx, requestKey{}, req)
+}
+
+// RequestFromContext returns the Request carried by ctx, or nil if there is none.
+func RequestFromContext(ctx context.Context) *Request {
+	req, _ := ctx.Value(requestKey{}).(*Request)
+	return req
+}
@@ -0,0 +1,73 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/charlinchui/galliard/message"
+)
+
+func TestRequestContext(t *testing.T) {
+	if RequestFromContext(context.Background()) != nil {
+		t.Errorf("Expected no request in empty context")
+	}
+	header := http.Header{}
+	header.Add("Cookie", "session=abc; theme=dark")
+	req := &Request{Transport: "long-polling", RemoteAddr: "10.0.0.1:5000", Header: header}
+	ctx := WithRequest(context.Background(), req)
+	if got := RequestFromContext(ctx); got != req {
+		t.Fatalf("Expected request to round-trip through context")
+	}
+	c, err := req.Cookie("theme")
+	if err != nil || c.Value != "dark" {
+		t.Errorf("Expected theme cookie 'dark', got %v, %v", c, err)
+	}
+}
+
+type headerPolicy struct{ allowAll }
+
+func (headerPolicy) CanPublish(ctx context.Context, _, _ string, _ *message.BayeuxMessage) bool {
+	req := RequestFromContext(ctx)
+	return req != nil && req.Header.Get("X-Publisher") == "yes"
+}
+
+func TestPolicySeesRequest(t *testing.T) {
+	srv, err := New(WithPolicy(headerPolicy{}))
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer srv.Close()
+	clientID := srv.HandleMessage(&message.BayeuxMessage{Channel: "/meta/handshake"}).ClientID
+	pub := &message.BayeuxMessage{Channel: "/foo", ClientID: clientID, Data: message.MustData(1)}
+
+	if resp := srv.HandleMessage(pub); *resp.Successful {
+		t.Errorf("Expected publish without request metadata to be denied")
+	}
+	ctx := WithRequest(context.Background(), &Request{Header: http.Header{"X-Publisher": {"yes"}}})
+	if resp := srv.HandleMessageContext(ctx, pub); !*resp.Successful {
+		t.Errorf("Expected publish with header to be allowed, got %+v", resp)
+	}
+}
+
+func TestHeldConnectCancelledByContext(t *testing.T) {
+	srv := NewServer()
+	defer srv.Close()
+	clientID := handshakeAndConnect(t, srv, "", 5000)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan *message.BayeuxMessage)
+	go func() {
+		done <- srv.HandleMessageContext(ctx, &message.BayeuxMessage{Channel: "/meta/connect", ClientID: clientID})
+	}()
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+	select {
+	case resp := <-done:
+		if resp.Channel != "/meta/connect" {
+			t.Errorf("Expected connect reply, got %q", resp.Channel)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Held connect ignored context cancellation")
+	}
+}
@@ -3,133 +3,563 @@
 package server
 
 import (
+	"context"
+	"errors"
+	"math"
+	"slices"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/charlinchui/galliard/internal/channel"
 	"github.com/charlinchui/galliard/internal/client"
-	"github.com/charlinchui/galliard/internal/utils"
+	"github.com/charlinchui/galliard/internal/fanout"
+	"github.com/charlinchui/galliard/internal/selector"
 	"github.com/charlinchui/galliard/message"
 )
 
 // Server implements a Bayeux protocol server.
 // It manages client sessions, channels, and routes Bayeux messages.
+//
+// Sessions and channels live in sharded registries whose shard locks are
+// never held while taking another lock. Beyond those, locks are taken in
+// this order, and never in reverse:
+//
+//	channel.Channel.mu, client.Session.mu
+//
+// browsersMu and the rate limiter's locks are leaves. Channel fan-out
+// iterates a copy-on-write subscriber snapshot, which subscribe and
+// unsubscribe rebuild under the channel lock, so publishing never holds a
+// channel lock while taking session locks.
 type Server struct {
-	Sessions   map[string]*client.Session
-	Channels   map[string]*channel.Channel
-	sessionsMu sync.RWMutex
-	channelsMu sync.RWMutex
+	sessions   *registry[*client.Session]
+	channels   *registry[*channel.Channel]
+	browsers   map[string]int
+	browsersMu sync.Mutex
+	opts       options
+	limiter    *rateLimiter
+	fanout     *fanout.Pool
+	stop       chan struct{}
+	stopOnce   sync.Once
 }
 
-func defaultAdvice() *message.Advice {
-	return &message.Advice{
-		Reconnect: "retry",
-		Interval:  0,
-		Timeout:   10000,
-	}
+func (s *Server) defaultAdvice() *message.Advice {
+	advice := s.opts.advice
+	return &advice
 }
 
+// setUpAdvice returns the advice for msg: the client's own, from msg or
+// its handshake, or else the server's. A client's timeout is capped at the
+// server's, so clients cannot hold connects open for longer.
 func (s *Server) setUpAdvice(msg *message.BayeuxMessage) *message.Advice {
-	if msg != nil && msg.Advice != nil {
-		return msg.Advice
+	advice := msg.Advice
+	if advice == nil {
+		if sess := s.getSession(msg.ClientID); sess != nil {
+			advice = sess.Advice
+		}
 	}
-	sess := s.getSession(msg.ClientID)
-	if sess != nil && sess.Advice != nil {
-		return sess.Advice
+	if advice == nil {
+		return s.defaultAdvice()
 	}
-	return defaultAdvice()
+	if advice.Timeout > s.opts.advice.Timeout {
+		a := *advice
+		a.Timeout = s.opts.advice.Timeout
+		return &a
+	}
+	return advice
 }
 
-// NewServer creates and returns a new Bayeux Server instance.
+// NewServer creates and returns a new Bayeux Server instance with default options.
+// Like New, it starts background goroutines for message fan-out, channel
+// sweeping and message expiry; call Close to stop them once the server is
+// no longer needed.
 func NewServer() *Server {
-	return &Server{
-		Sessions: make(map[string]*client.Session),
-		Channels: make(map[string]*channel.Channel),
+	s, _ := New()
+	return s
+}
+
+// New creates a Bayeux Server configured by opts.
+// It returns an error if the options are invalid or inconsistent.
+// Call Close to stop its background workers once the server is no longer
+// needed.
+func New(opts ...Option) (*Server, error) {
+	o := defaultOptions()
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if err := o.validate(); err != nil {
+		return nil, err
+	}
+	s := &Server{
+		sessions: newRegistry[*client.Session](),
+		channels: newRegistry[*channel.Channel](),
+		browsers: make(map[string]int),
+		opts:     o,
+		limiter:  &rateLimiter{limits: o.rateLimits},
+		fanout:   fanout.NewPool(o.fanoutWorkers, o.fanoutQueue),
+		stop:     make(chan struct{}),
+	}
+	if o.sessionTimeout > 0 {
+		go s.every(o.sessionTimeout/2, s.expireSessions)
+	}
+	if o.channelSweep > 0 {
+		go s.every(o.channelSweep, s.sweepChannels)
+	}
+	if o.expirySweep > 0 {
+		go s.every(o.expirySweep, s.dropExpiredMessages)
+	}
+	return s, nil
+}
+
+// Close stops background work such as session expiry and channel sweeping,
+// after delivering messages already published. Existing sessions are kept
+// and the server can still handle messages; later publishes are delivered
+// synchronously.
+func (s *Server) Close() {
+	s.stopOnce.Do(func() { close(s.stop) })
+	s.fanout.Close()
+}
+
+// publish hands a delivery copy of msg to the fan-out workers for delivery
+// to the subscribers of ch and of the wildcard channels matching it. Unless
+// msg already expires, the copy gets the channel's TTL.
+func (s *Server) publish(ch *channel.Channel, msg *message.BayeuxMessage) {
+	msg = deliveryMessage(msg)
+	now := s.opts.clock.Now()
+	ch.Touch(now)
+	if ttl := ch.TTL(); ttl > 0 && msg.Expires.IsZero() {
+		msg.Expires = now.Add(ttl)
+	}
+	s.fanout.Submit(ch.Name, func() {
+		var wildcards []*channel.Channel
+		for _, pattern := range channel.Wildcards(ch.Name) {
+			if wc, ok := s.channels.get(pattern); ok {
+				wildcards = append(wildcards, wc)
+			}
+		}
+		ch.Publish(msg, wildcards...)
+		if ch.Retain {
+			s.saveRetained(ch, msg)
+		}
+	})
+}
+
+// deliveryMessage returns the message delivered to subscribers for msg. It
+// carries the channel, data and id, but never the publisher's clientId,
+// which would let subscribers act as its session, nor its ext, which may
+// hold credentials.
+func deliveryMessage(msg *message.BayeuxMessage) *message.BayeuxMessage {
+	return &message.BayeuxMessage{
+		Channel:       msg.Channel,
+		Data:          msg.Data,
+		ID:            msg.ID,
+		Lazy:          msg.Lazy,
+		Expires:       msg.Expires,
+		ConflationKey: msg.ConflationKey,
+	}
+}
+
+// every calls f every d until the server is closed.
+func (s *Server) every(d time.Duration, f func()) {
+	ticker := time.NewTicker(d)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			f()
+		}
+	}
+}
+
+// expireSessions removes sessions not heard from within the session timeout.
+func (s *Server) expireSessions() {
+	deadline := s.opts.clock.Now().Add(-s.opts.sessionTimeout)
+	var expired []string
+	for _, sess := range s.sessions.values() {
+		if sess.LastSeen().Before(deadline) {
+			expired = append(expired, sess.ID)
+		}
+	}
+	for _, id := range expired {
+		if s.removeSession(id) {
+			s.opts.metrics.IncCounter(MetricSessionsExpired, 1)
+			s.opts.logger.Info("session expired", "clientId", id)
+		}
+	}
+}
+
+// dropExpiredMessages discards expired messages from every session queue.
+func (s *Server) dropExpiredMessages() {
+	for _, sess := range s.sessions.values() {
+		sess.DropExpired()
 	}
 }
 
 func (s *Server) registerSession(id string) *client.Session {
-	s.sessionsMu.Lock()
-	defer s.sessionsMu.Unlock()
 	sess := client.NewSession(id)
-	s.Sessions[id] = sess
+	sess.MaxQueue = s.opts.maxQueue
+	sess.MaxLazy = s.opts.maxLazy
+	sess.OnDrop = func(*message.BayeuxMessage) {
+		s.opts.metrics.IncCounter(MetricMessagesDropped, 1)
+	}
+	sess.OnExpire = func(*message.BayeuxMessage) {
+		s.opts.metrics.IncCounter(MetricMessagesExpired, 1)
+	}
+	sess.Now = s.opts.clock.Now
+	sess.Touch(s.opts.clock.Now())
+	s.sessions.put(id, sess)
+	s.opts.metrics.IncCounter(MetricSessionsOpened, 1)
+	s.opts.metrics.SetGauge(MetricSessionsActive, int64(s.sessions.len()))
+	s.opts.logger.Debug("session opened", "clientId", id)
 	return sess
 }
 
 func (s *Server) getSession(id string) *client.Session {
-	s.sessionsMu.RLock()
-	defer s.sessionsMu.RUnlock()
-	return s.Sessions[id]
-}
-
-func (s *Server) getOrCreateChannel(chName string) *channel.Channel {
-	s.channelsMu.Lock()
-	defer s.channelsMu.Unlock()
-	ch, ok := s.Channels[chName]
-	if !ok {
-		ch = channel.NewChannel(chName)
-		s.Channels[chName] = ch
-	}
-	return ch
+	sess, _ := s.sessions.get(id)
+	return sess
 }
 
 // HandleMessage processes a BayeuxMessage and returns a response message.
 // It handles all Bayeux meta channels and data publish messages.
+//
+// A /meta/connect may deliver several messages, but HandleMessage returns
+// only one: the first delivered message, leaving the rest queued for the
+// next connect, or else the connect reply. Transports should use
+// HandleMessages, which returns them all.
 func (s *Server) HandleMessage(msg *message.BayeuxMessage) *message.BayeuxMessage {
+	return s.HandleMessageContext(context.Background(), msg)
+}
+
+// HandleMessageContext is like HandleMessage but takes a context, which
+// cancels a held /meta/connect when done and carries the transport Request
+// (see WithRequest) to policies. If the Request has a BrowserID, the server
+// holds at most the configured number of connects per browser; further
+// clients sharing that browser get a "multiple-clients" advice instead.
+func (s *Server) HandleMessageContext(ctx context.Context, msg *message.BayeuxMessage) *message.BayeuxMessage {
+	replies := s.handle(ctx, msg)
+	if msg.Channel == "/meta/connect" && len(replies) > 1 {
+		if sess := s.getSession(msg.ClientID); sess != nil {
+			sess.Requeue(replies[1 : len(replies)-1])
+		}
+	}
+	return replies[0]
+}
+
+// HandleMessages processes a batch of messages received together and
+// returns all the replies and delivered messages for the batch.
+// Any /meta/connect is processed after the other messages, as Bayeux
+// requires, and its reply is preceded by the messages it delivers.
+// With WithPiggyback, batches without a connect also deliver the sending
+// sessions' queued messages, after the replies.
+func (s *Server) HandleMessages(msgs []*message.BayeuxMessage) []*message.BayeuxMessage {
+	return s.HandleMessagesContext(context.Background(), msgs)
+}
+
+// HandleMessagesContext is like HandleMessages but takes a context, as
+// HandleMessageContext does.
+func (s *Server) HandleMessagesContext(ctx context.Context, msgs []*message.BayeuxMessage) []*message.BayeuxMessage {
+	var replies, connects []*message.BayeuxMessage
+	for _, msg := range msgs {
+		if msg.Channel == "/meta/connect" {
+			connects = append(connects, msg)
+			continue
+		}
+		replies = append(replies, s.handle(ctx, msg)...)
+	}
+	for _, msg := range connects {
+		replies = append(replies, s.handle(ctx, msg)...)
+	}
+	if s.opts.piggyback && len(connects) == 0 {
+		replies = append(replies, s.piggyback(msgs)...)
+	}
+	return replies
+}
+
+// piggyback dequeues the messages waiting for the sessions that sent msgs,
+// so they can be delivered with the batch's replies.
+func (s *Server) piggyback(msgs []*message.BayeuxMessage) []*message.BayeuxMessage {
+	var delivered []*message.BayeuxMessage
+	seen := make(map[string]bool)
+	for _, msg := range msgs {
+		if msg.ClientID == "" || seen[msg.ClientID] {
+			continue
+		}
+		seen[msg.ClientID] = true
+		if sess := s.getSession(msg.ClientID); sess != nil {
+			delivered = append(delivered, sess.DequeueAll()...)
+		}
+	}
+	return delivered
+}
+
+// handle validates and dispatches msg. The reply to msg comes last; only
+// /meta/connect produces more than one message.
+func (s *Server) handle(ctx context.Context, msg *message.BayeuxMessage) []*message.BayeuxMessage {
 	if errResp := validateMessage(msg, s); errResp != nil {
-		return errResp
+		return []*message.BayeuxMessage{errResp}
 	}
+	if sess := s.getSession(msg.ClientID); sess != nil {
+		sess.Touch(s.opts.clock.Now())
+	}
+	var reply *message.BayeuxMessage
 	switch msg.Channel {
 	case "/meta/handshake":
-		return s.handleHandshake(msg)
+		reply = s.handleHandshake(ctx, msg)
 	case "/meta/connect":
-		return s.handleConnect(msg)
+		return s.handleConnect(ctx, msg)
 	case "/meta/subscribe":
-		return s.handleSubscribe(msg)
+		reply = s.handleSubscribe(ctx, msg)
 	case "/meta/unsubscribe":
-		return s.handleUnsubscribe(msg)
+		reply = s.handleUnsubscribe(msg)
 	case "/meta/disconnect":
-		return s.handleDisconnect(msg)
+		reply = s.handleDisconnect(msg)
 	default:
-		return s.handlePublish(msg)
+		reply = s.handlePublish(ctx, msg)
 	}
+	return []*message.BayeuxMessage{reply}
 }
 
-func (s *Server) handleHandshake(msg *message.BayeuxMessage) *message.BayeuxMessage {
-	clientID := utils.GenerateID()
+func (s *Server) handleHandshake(ctx context.Context, msg *message.BayeuxMessage) *message.BayeuxMessage {
+	var principal *Principal
+	if s.opts.authenticator != nil {
+		p, err := s.opts.authenticator.Authenticate(ctx, msg)
+		if err != nil {
+			s.opts.metrics.IncCounter(MetricRequestsDenied, 1)
+			resp := s.errorResponse(msg.Channel, msg.ID, "401::"+err.Error())
+			resp.Advice.Reconnect = "none"
+			return resp
+		}
+		principal = p
+	}
+	if !s.opts.policy.CanHandshake(ctx, msg) {
+		return s.deny(msg, "403::Handshake denied")
+	}
+	if len(msg.SupportedConnectionTypes) > 0 && !slices.ContainsFunc(msg.SupportedConnectionTypes, supportsConnectionType) {
+		resp := s.errorResponse(msg.Channel, msg.ID, "400:"+strings.Join(msg.SupportedConnectionTypes, ",")+":Unsupported connection types")
+		resp.Version = BayeuxVersion
+		resp.SupportedConnectionTypes = connectionTypes
+		return resp
+	}
+	clientID, ok := s.newClientID()
+	if !ok {
+		s.opts.logger.Error("generating client ID failed", "attempts", maxIDAttempts)
+		return s.errorResponse(msg.Channel, msg.ID, "500::Could not assign a client ID")
+	}
 	sess := s.registerSession(clientID)
+	sess.SetPrincipal(principal)
+	if req := RequestFromContext(ctx); req != nil {
+		sess.SetTransport(req.Transport)
+	}
 	if msg.Advice != nil {
 		sess.Advice = msg.Advice
 	}
 	success := true
 	return &message.BayeuxMessage{
-		Channel:    "/meta/handshake",
-		Successful: &success,
-		ClientID:   clientID,
-		ID:         msg.ID,
-		Advice:     s.setUpAdvice(msg),
+		Channel:                  "/meta/handshake",
+		Version:                  BayeuxVersion,
+		SupportedConnectionTypes: connectionTypes,
+		Successful:               &success,
+		ClientID:                 clientID,
+		ID:                       msg.ID,
+		Advice:                   s.setUpAdvice(msg),
+	}
+}
+
+// UnsuccessfulChannel is the channel of error replies to messages that
+// did not name a channel, so that every reply has one.
+const UnsuccessfulChannel = "/meta/unsuccessful"
+
+// BayeuxVersion is the protocol version the server implements.
+const BayeuxVersion = "1.0"
+
+// FilterExt is the ext field of a subscribe message that carries a
+// subscription filter; the subscriber then only receives messages whose
+// data matches it. A filter combines predicates on dotted field paths with
+// &&, || and !, for example
+//
+//	symbol in ("AAPL", "MSFT") && (quote.bid >= 100 || halted == true)
+//
+// Predicates compare with ==, !=, <, <=, > and >= against strings, numbers,
+// true, false and null. A predicate on a missing field, or comparing values
+// of different types, is false. Invalid filters are rejected with a 400
+// error.
+const FilterExt = "filter"
+
+// TTLExt is the ext field with which a publisher sets how many
+// milliseconds its message may wait in subscriber queues before it is
+// discarded as stale. It overrides the channel's TTL.
+const TTLExt = "ttl"
+
+// maxTTL is the largest TTLExt value, in milliseconds, that fits a
+// time.Duration.
+const maxTTL = int64(math.MaxInt64 / time.Millisecond)
+
+// ConflationKeyExt is the ext field with which a publisher sets the
+// conflation key of a message published to a conflating channel. See
+// WithConflatingChannels.
+const ConflationKeyExt = "conflationKey"
+
+// connectionTypes lists the connection types the server's transports offer.
+var connectionTypes = []string{"long-polling"}
+
+func supportsConnectionType(t string) bool {
+	return slices.Contains(connectionTypes, t)
+}
+
+// maxIDAttempts is how many IDs newClientID tries before giving up.
+const maxIDAttempts = 10
+
+// newClientID generates a client ID not used by any current session. It
+// reports false if the ID generator keeps returning empty or used IDs.
+func (s *Server) newClientID() (string, bool) {
+	for range maxIDAttempts {
+		id := s.opts.newID()
+		if id != "" && s.getSession(id) == nil {
+			return id, true
+		}
 	}
+	return "", false
 }
 
-func (s *Server) handleConnect(msg *message.BayeuxMessage) *message.BayeuxMessage {
+// handleConnect returns the messages delivered to the session followed by
+// the connect reply.
+func (s *Server) handleConnect(ctx context.Context, msg *message.BayeuxMessage) []*message.BayeuxMessage {
+	var browserID string
+	if req := RequestFromContext(ctx); req != nil {
+		browserID = req.BrowserID
+	}
 	sess := s.getSession(msg.ClientID)
-	queued := sess.DequeueAll()
-	if len(queued) > 0 {
-		return queued[0]
+	advice := s.setUpAdvice(msg)
+	if msg.ConnectionType != "" {
+		sess.SetTransport(msg.ConnectionType)
 	}
-	return &message.BayeuxMessage{
+	first := sess.MarkConnected()
+	var queued []*message.BayeuxMessage
+	if first || sess.Due() {
+		queued = sess.DequeueAll()
+	} else {
+		if s.acquireBrowser(browserID) {
+			queued = waitForMessages(ctx, sess, advice)
+			s.releaseBrowser(browserID)
+			sess.Touch(s.opts.clock.Now())
+		} else {
+			advice = s.multipleClientsAdvice(advice)
+		}
+	}
+	if s.limiter.takeThrottled(sess.ID) {
+		advice = s.throttledAdvice(advice)
+	}
+	success := true
+	return append(queued, &message.BayeuxMessage{
 		Channel:    "/meta/connect",
 		ClientID:   sess.ID,
-		Successful: nil,
+		Successful: &success,
 		ID:         msg.ID,
-		Advice:     s.setUpAdvice(msg),
+		Advice:     advice,
+	})
+}
+
+// waitForMessages holds a connect until messages are due for sess, the
+// session is closed, the advice timeout expires or ctx is done. If ctx is
+// done, the request has been abandoned, so it returns nothing and leaves
+// the messages queued for the next connect.
+func waitForMessages(ctx context.Context, sess *client.Session, advice *message.Advice) []*message.BayeuxMessage {
+	if advice.Timeout <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(time.Duration(advice.Timeout) * time.Millisecond)
+	defer timer.Stop()
+	for {
+		select {
+		case <-sess.Wake():
+			if !sess.Due() {
+				// A stale wake-up, or the messages are lazy and may
+				// wait for more to join them.
+				continue
+			}
+		case <-sess.Done():
+		case <-ctx.Done():
+			return nil
+		case <-timer.C:
+		}
+		return sess.DequeueAll()
 	}
 }
 
-func (s *Server) handleSubscribe(msg *message.BayeuxMessage) *message.BayeuxMessage {
+// throttledAdvice raises the polling interval for a rate-limited session.
+func (s *Server) throttledAdvice(advice *message.Advice) *message.Advice {
+	interval := int(s.opts.rateLimits.ThrottleInterval / time.Millisecond)
+	if interval <= advice.Interval {
+		return advice
+	}
+	a := *advice
+	a.Interval = interval
+	return &a
+}
+
+func (s *Server) multipleClientsAdvice(advice *message.Advice) *message.Advice {
+	a := *advice
+	a.Reconnect = "retry"
+	a.Interval = int(s.opts.multipleClientsInterval / time.Millisecond)
+	a.MultipleClients = true
+	return &a
+}
+
+// acquireBrowser reserves a held connect slot for browserID,
+// reporting false if the browser already uses all of its slots.
+func (s *Server) acquireBrowser(browserID string) bool {
+	if browserID == "" {
+		return true
+	}
+	s.browsersMu.Lock()
+	defer s.browsersMu.Unlock()
+	if s.browsers[browserID] >= s.opts.maxConnectsPerBrowser {
+		return false
+	}
+	s.browsers[browserID]++
+	return true
+}
+
+func (s *Server) releaseBrowser(browserID string) {
+	if browserID == "" {
+		return
+	}
+	s.browsersMu.Lock()
+	defer s.browsersMu.Unlock()
+	if s.browsers[browserID] <= 1 {
+		delete(s.browsers, browserID)
+		return
+	}
+	s.browsers[browserID]--
+}
+
+func (s *Server) handleSubscribe(ctx context.Context, msg *message.BayeuxMessage) *message.BayeuxMessage {
+	filter, err := subscriptionFilter(msg)
+	if err != nil {
+		resp := s.errorResponse(msg.Channel, msg.ID, "400:"+msg.Subscription+":Invalid filter: "+err.Error())
+		resp.Subscription = msg.Subscription
+		return resp
+	}
+	if !s.opts.policy.CanSubscribe(ctx, msg.ClientID, msg.Subscription, msg) {
+		return s.deny(msg, "403:"+msg.Subscription+":Subscription denied")
+	}
 	sess := s.getSession(msg.ClientID)
-	ch := s.getOrCreateChannel(msg.Subscription)
-	ch.Subscribe(sess)
 	sess.Subscribe(msg.Subscription)
+	ch := s.getOrCreateChannel(msg.Subscription)
+	for !ch.SubscribeFiltered(sess, filter) {
+		// The channel was swept in between; subscribe to its replacement.
+		ch = s.getOrCreateChannel(msg.Subscription)
+	}
+	ch.Touch(s.opts.clock.Now())
+	select {
+	case <-sess.Done():
+		// The session was removed concurrently, possibly before it saw this
+		// subscription; make sure the channel does not keep it.
+		ch.Unsubscribe(sess)
+	default:
+	}
 	success := true
 	return &message.BayeuxMessage{
 		Channel:      "/meta/subscribe",
@@ -139,10 +569,24 @@ func (s *Server) handleSubscribe(msg *message.BayeuxMessage) *message.BayeuxMess
 	}
 }
 
+// subscriptionFilter parses the FilterExt field of msg, returning nil if
+// it has none.
+func subscriptionFilter(msg *message.BayeuxMessage) (*selector.Selector, error) {
+	var expr string
+	if ok, err := msg.DecodeExt(FilterExt, &expr); err != nil {
+		return nil, errors.New("filter must be a string")
+	} else if !ok || expr == "" {
+		return nil, nil
+	}
+	return selector.Parse(expr)
+}
+
 func (s *Server) handleUnsubscribe(msg *message.BayeuxMessage) *message.BayeuxMessage {
-	ch := s.getOrCreateChannel(msg.Subscription)
 	sess := s.getSession(msg.ClientID)
-	ch.Unsubscribe(sess)
+	if ch, ok := s.getChannel(msg.Subscription); ok {
+		ch.Unsubscribe(sess)
+		ch.Touch(s.opts.clock.Now())
+	}
 	sess.Unsubscribe(msg.Subscription)
 	success := true
 	return &message.BayeuxMessage{
@@ -154,19 +598,7 @@ func (s *Server) handleUnsubscribe(msg *message.BayeuxMessage) *message.BayeuxMe
 }
 
 func (s *Server) handleDisconnect(msg *message.BayeuxMessage) *message.BayeuxMessage {
-	s.sessionsMu.Lock()
-	defer s.sessionsMu.Unlock()
-	sess, ok := s.Sessions[msg.ClientID]
-	if ok {
-		s.channelsMu.Lock()
-		defer s.channelsMu.Unlock()
-		for sub := range sess.Subscriptions {
-			if ch, exists := s.Channels[sub]; exists {
-				ch.Unsubscribe(sess)
-			}
-		}
-		delete(s.Sessions, msg.ClientID)
-	}
+	s.removeSession(msg.ClientID)
 	success := true
 	return &message.BayeuxMessage{
 		Channel:    "/meta/disconnect",
@@ -175,9 +607,53 @@ func (s *Server) handleDisconnect(msg *message.BayeuxMessage) *message.BayeuxMes
 	}
 }
 
-func (s *Server) handlePublish(msg *message.BayeuxMessage) *message.BayeuxMessage {
+// removeSession forgets the session and unsubscribes it from all channels,
+// reporting whether it existed.
+// The session is closed before its subscriptions are read, so a concurrent
+// handleSubscribe either has its subscription listed here or sees the
+// session closed and undoes it.
+func (s *Server) removeSession(id string) bool {
+	sess, ok := s.sessions.delete(id)
+	if !ok {
+		return false
+	}
+	sess.Close()
+	for _, sub := range sess.SubscriptionList() {
+		if ch, exists := s.channels.get(sub); exists {
+			ch.Unsubscribe(sess)
+			ch.Touch(s.opts.clock.Now())
+		}
+	}
+	s.limiter.forget(id)
+	s.opts.metrics.IncCounter(MetricSessionsClosed, 1)
+	s.opts.metrics.SetGauge(MetricSessionsActive, int64(s.sessions.len()))
+	s.opts.logger.Debug("session closed", "clientId", id)
+	return true
+}
+
+func (s *Server) handlePublish(ctx context.Context, msg *message.BayeuxMessage) *message.BayeuxMessage {
+	var ttl int64
+	if ok, err := msg.DecodeExt(TTLExt, &ttl); err != nil || (ok && (ttl <= 0 || ttl > maxTTL)) {
+		return s.errorResponse(msg.Channel, msg.ID, "400:"+msg.Channel+":Invalid ttl")
+	} else if ok {
+		msg.Expires = s.opts.clock.Now().Add(time.Duration(ttl) * time.Millisecond)
+	}
+	if _, err := msg.DecodeExt(ConflationKeyExt, &msg.ConflationKey); err != nil {
+		return s.errorResponse(msg.Channel, msg.ID, "400:"+msg.Channel+":Invalid conflation key")
+	}
+	if !s.opts.policy.CanPublish(ctx, msg.ClientID, msg.Channel, msg) {
+		return s.deny(msg, "403:"+msg.Channel+":Publish denied")
+	}
+	if ok, metric := s.limiter.allow(msg.ClientID, s.Principal(msg.ClientID), msg.Channel, s.opts.clock.Now()); !ok {
+		s.opts.metrics.IncCounter(metric, 1)
+		return s.errorResponse(msg.Channel, msg.ID, "429:"+msg.Channel+":Rate limit exceeded")
+	}
 	ch := s.getOrCreateChannel(msg.Channel)
-	ch.Publish(msg)
+	if err := ch.Intercept(ctx, msg); err != nil {
+		return s.deny(msg, "403:"+msg.Channel+":"+err.Error())
+	}
+	s.publish(ch, msg)
+	s.opts.metrics.IncCounter(MetricMessagesPublished, 1)
 	success := true
 	return &message.BayeuxMessage{
 		Channel:    msg.Channel,
@@ -186,47 +662,68 @@ func (s *Server) handlePublish(msg *message.BayeuxMessage) *message.BayeuxMessag
 	}
 }
 
-func errorResponse(channel, id, errMsg string) *message.BayeuxMessage {
+// deny builds the reply for a request rejected by the policy.
+func (s *Server) deny(msg *message.BayeuxMessage, errMsg string) *message.BayeuxMessage {
+	s.opts.metrics.IncCounter(MetricRequestsDenied, 1)
+	resp := s.errorResponse(msg.Channel, msg.ID, errMsg)
+	resp.Subscription = msg.Subscription
+	return resp
+}
+
+func (s *Server) errorResponse(channel, id, errMsg string) *message.BayeuxMessage {
 	success := false
 	return &message.BayeuxMessage{
 		Channel:    channel,
 		Successful: &success,
 		Error:      errMsg,
 		ID:         id,
-		Advice:     defaultAdvice(),
+		Advice:     s.defaultAdvice(),
 	}
 }
 
+// validateMessage checks the fields msg requires and that its client is
+// known, returning an error reply if it is not valid.
 func validateMessage(msg *message.BayeuxMessage, s *Server) *message.BayeuxMessage {
+	fail := func(errMsg string) *message.BayeuxMessage {
+		resp := s.errorResponse(msg.Channel, msg.ID, errMsg)
+		resp.Subscription = msg.Subscription
+		return resp
+	}
 	switch msg.Channel {
+	case "":
+		resp := fail("400::Missing channel")
+		resp.Channel = UnsuccessfulChannel
+		return resp
 	case "/meta/handshake":
 		return nil
+	}
+	if msg.ClientID == "" {
+		return fail("400::Missing clientId")
+	}
+	if s.getSession(msg.ClientID) == nil {
+		// The session is gone, typically expired; the client must handshake again.
+		resp := fail("402:" + msg.ClientID + ":Unknown client")
+		resp.Advice.Reconnect = "handshake"
+		return resp
+	}
+	switch msg.Channel {
 	case "/meta/connect", "/meta/disconnect":
-		if msg.ClientID == "" {
-			return errorResponse(msg.Channel, msg.ID, "Missing clientId")
-		}
-		if s.getSession(msg.ClientID) == nil {
-			return errorResponse(msg.Channel, msg.ID, "Unknown ClientID")
-		}
 	case "/meta/subscribe", "/meta/unsubscribe":
-		if msg.ClientID == "" {
-			return errorResponse(msg.Channel, msg.ID, "Missing clientId")
-		}
 		if msg.Subscription == "" {
-			return errorResponse(msg.Channel, msg.ID, "Missing subscription")
+			return fail("400::Missing subscription")
 		}
-		if s.getSession(msg.ClientID) == nil {
-			return errorResponse(msg.Channel, msg.ID, "Unknown ClientID")
+		if !channel.Valid(msg.Subscription) {
+			return fail("405:" + msg.Subscription + ":Invalid channel")
 		}
-	default:
-		if msg.ClientID == "" {
-			return errorResponse(msg.Channel, msg.ID, "Missing clientId")
+		if strings.HasPrefix(msg.Subscription, "/meta/") {
+			return fail("403:" + msg.Subscription + ":Meta channels cannot be subscribed to")
 		}
-		if msg.Channel == "" {
-			return errorResponse(msg.Channel, msg.ID, "Missing channel")
+	default:
+		if !channel.Valid(msg.Channel) || channel.IsWildcard(msg.Channel) || strings.HasPrefix(msg.Channel, "/meta/") {
+			return fail("405:" + msg.Channel + ":Invalid channel")
 		}
-		if s.getSession(msg.ClientID) == nil {
-			return errorResponse(msg.Channel, msg.ID, "Unknown ClientID")
+		if !msg.HasData() {
+			return fail("400:" + msg.Channel + ":Missing data")
 		}
 	}
 	return nil
@@ -1,26 +1,34 @@
 package server
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/charlinchui/galliard/message"
 )
 
 func TestNewServer(t *testing.T) {
 	srv := NewServer()
+	defer srv.Close()
 	if srv == nil {
 		t.Fatal("Expected non-nil server")
 	}
-	if len(srv.Sessions) != 0 {
+	if srv.SessionCount() != 0 {
 		t.Errorf("Expected no sessions")
 	}
-	if len(srv.Channels) != 0 {
+	if srv.ChannelCount() != 0 {
 		t.Errorf("Expected no channels")
 	}
 }
 
 func TestRegisterNewSession(t *testing.T) {
 	srv := NewServer()
+	defer srv.Close()
 	id := "client-xyz"
 	s := srv.registerSession(id)
 	if s.ID != id {
@@ -33,6 +41,7 @@ func TestRegisterNewSession(t *testing.T) {
 
 func TestGetOrCreateChannel(t *testing.T) {
 	srv := NewServer()
+	defer srv.Close()
 	ch1 := srv.getOrCreateChannel("/foo")
 	if ch1.Name != "/foo" {
 		t.Errorf("Expected channel name to be '/foo'")
@@ -45,6 +54,7 @@ func TestGetOrCreateChannel(t *testing.T) {
 
 func TestHandleHandshake(t *testing.T) {
 	srv := NewServer()
+	defer srv.Close()
 	req := &message.BayeuxMessage{Channel: "/meta/handshake"}
 	resp := srv.HandleMessage(req)
 	if resp.Channel != "/meta/handshake" {
@@ -66,6 +76,7 @@ func TestHandleHandshake(t *testing.T) {
 
 func TestHandleAndSubscribe(t *testing.T) {
 	srv := NewServer()
+	defer srv.Close()
 	handshake := &message.BayeuxMessage{Channel: "/meta/handshake"}
 	resp := srv.HandleMessage(handshake)
 	clientID := resp.ClientID
@@ -83,14 +94,13 @@ func TestHandleAndSubscribe(t *testing.T) {
 	publish := &message.BayeuxMessage{
 		Channel:  "/foo",
 		ClientID: clientID,
-		Data: map[string]interface{}{
-			"msg": "hello",
-		},
+		Data:     message.MustData(map[string]interface{}{"msg": "hello"}),
 	}
 	pubResp := srv.HandleMessage(publish)
 	if pubResp.Successful == nil || !*pubResp.Successful {
 		t.Errorf("Expected successful publish")
 	}
+	waitForFanout(t, srv)
 
 	connect := &message.BayeuxMessage{
 		Channel:  "/meta/connect",
@@ -100,8 +110,9 @@ func TestHandleAndSubscribe(t *testing.T) {
 	if connResp.Channel != "/foo" {
 		t.Errorf("Expected message from '/foo', got %q", connResp.Channel)
 	}
-	if connResp.Data["msg"] != "hello" {
-		t.Errorf("Expected data 'hello', got %v", connResp.Data["msg"])
+	var data map[string]string
+	if err := connResp.DecodeData(&data); err != nil || data["msg"] != "hello" {
+		t.Errorf("Expected data 'hello', got %s", connResp.Data)
 	}
 	if connResp.Channel == "/meta/connect" && connResp.Advice == nil {
 		t.Errorf("Expected advice in connect response")
@@ -110,6 +121,7 @@ func TestHandleAndSubscribe(t *testing.T) {
 
 func TestHandleUnsubscribe(t *testing.T) {
 	srv := NewServer()
+	defer srv.Close()
 	handshake := &message.BayeuxMessage{Channel: "/meta/handshake"}
 	resp := srv.HandleMessage(handshake)
 	clientID := resp.ClientID
@@ -131,6 +143,7 @@ func TestHandleUnsubscribe(t *testing.T) {
 
 func TestHandleDisconnect(t *testing.T) {
 	srv := NewServer()
+	defer srv.Close()
 	handshake := &message.BayeuxMessage{Channel: "/meta/handshake"}
 	resp := srv.HandleMessage(handshake)
 	clientID := resp.ClientID
@@ -151,6 +164,7 @@ func TestHandleDisconnect(t *testing.T) {
 
 func TestMessageIDCorrelation(t *testing.T) {
 	srv := NewServer()
+	defer srv.Close()
 	handshake := &message.BayeuxMessage{Channel: "/meta/handshake", ID: "h1"}
 	resp := srv.HandleMessage(handshake)
 	if resp.ID != "h1" {
@@ -172,6 +186,7 @@ func TestMessageIDCorrelation(t *testing.T) {
 
 func TestErrorHandling_MissingFields(t *testing.T) {
 	srv := NewServer()
+	defer srv.Close()
 	connect := &message.BayeuxMessage{Channel: "/meta/connect", ID: "c1"}
 	resp := srv.HandleMessage(connect)
 	if resp.Successful == nil || *resp.Successful != false {
@@ -209,6 +224,7 @@ func TestErrorHandling_MissingFields(t *testing.T) {
 
 func TestErrorHandling_UnknownClient(t *testing.T) {
 	srv := NewServer()
+	defer srv.Close()
 	subscribe := &message.BayeuxMessage{
 		Channel:      "/meta/subscribe",
 		ClientID:     "not-a-client",
@@ -227,3 +243,536 @@ func TestErrorHandling_UnknownClient(t *testing.T) {
 	}
 
 }
+
+// waitForFanout waits until published messages have been delivered to
+// subscriber queues.
+func waitForFanout(t *testing.T, srv *Server) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for srv.FanoutQueueDepth() > 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("Published messages were not delivered")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func browserContext(browserID string) context.Context {
+	return WithRequest(context.Background(), &Request{BrowserID: browserID})
+}
+
+func handshakeAndConnect(t *testing.T, srv *Server, browserID string, timeout int) string {
+	t.Helper()
+	resp := srv.HandleMessage(&message.BayeuxMessage{
+		Channel: "/meta/handshake",
+		Advice:  &message.Advice{Timeout: timeout},
+	})
+	srv.HandleMessageContext(browserContext(browserID), &message.BayeuxMessage{Channel: "/meta/connect", ClientID: resp.ClientID})
+	return resp.ClientID
+}
+
+func TestHeldConnectWokenByPublish(t *testing.T) {
+	srv := NewServer()
+	defer srv.Close()
+	clientID := handshakeAndConnect(t, srv, "", 5000)
+	srv.HandleMessage(&message.BayeuxMessage{Channel: "/meta/subscribe", ClientID: clientID, Subscription: "/foo"})
+
+	done := make(chan *message.BayeuxMessage)
+	go func() {
+		done <- srv.HandleMessage(&message.BayeuxMessage{Channel: "/meta/connect", ClientID: clientID})
+	}()
+	time.Sleep(20 * time.Millisecond)
+	srv.HandleMessage(&message.BayeuxMessage{Channel: "/foo", ClientID: clientID, Data: message.MustData("hi")})
+
+	select {
+	case resp := <-done:
+		if resp.Channel != "/foo" {
+			t.Errorf("Expected held connect to deliver /foo, got %q", resp.Channel)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Held connect was not woken by publish")
+	}
+}
+
+func TestHeldConnectTimesOut(t *testing.T) {
+	srv := NewServer()
+	defer srv.Close()
+	clientID := handshakeAndConnect(t, srv, "", 30)
+	start := time.Now()
+	resp := srv.HandleMessage(&message.BayeuxMessage{
+		Channel:  "/meta/connect",
+		ClientID: clientID,
+		Advice:   &message.Advice{Timeout: 30},
+	})
+	if resp.Channel != "/meta/connect" {
+		t.Errorf("Expected connect reply, got %q", resp.Channel)
+	}
+	if time.Since(start) < 30*time.Millisecond {
+		t.Errorf("Expected connect to be held until the advice timeout")
+	}
+}
+
+func TestClientAdviceTimeoutIsCapped(t *testing.T) {
+	srv, err := New(WithAdvice(message.Advice{Reconnect: "retry", Timeout: 30}))
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer srv.Close()
+	clientID := handshakeAndConnect(t, srv, "", 60000)
+	start := time.Now()
+	resp := srv.HandleMessage(&message.BayeuxMessage{
+		Channel:  "/meta/connect",
+		ClientID: clientID,
+		Advice:   &message.Advice{Timeout: 60000},
+	})
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected connect to be held for the server's timeout, held for %v", elapsed)
+	}
+	if resp.Advice.Timeout != 30 {
+		t.Errorf("Expected advice timeout capped at 30, got %d", resp.Advice.Timeout)
+	}
+}
+
+func TestCancelledConnectLeavesMessagesQueued(t *testing.T) {
+	srv, err := New(WithMaxLazy(time.Hour))
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer srv.Close()
+	clientID := handshakeAndConnect(t, srv, "", 5000)
+	srv.HandleMessage(&message.BayeuxMessage{Channel: "/meta/subscribe", ClientID: clientID, Subscription: "/foo"})
+	srv.PublishMessage(&message.BayeuxMessage{Channel: "/foo", Data: message.MustData("lazy"), Lazy: true})
+	waitForFanout(t, srv)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan []*message.BayeuxMessage)
+	go func() {
+		done <- srv.HandleMessagesContext(ctx, []*message.BayeuxMessage{{Channel: "/meta/connect", ClientID: clientID}})
+	}()
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+	if msgs := <-done; len(msgs) != 1 || msgs[0].Channel != "/meta/connect" {
+		t.Errorf("Expected only the connect reply to the cancelled connect, got %d messages", len(msgs))
+	}
+
+	msgs := srv.HandleMessages([]*message.BayeuxMessage{{
+		Channel:  "/meta/connect",
+		ClientID: clientID,
+		Advice:   &message.Advice{Timeout: 30},
+	}})
+	if len(msgs) != 2 || string(msgs[0].Data) != `"lazy"` {
+		t.Errorf("Expected the next connect to deliver the queued message, got %d messages", len(msgs))
+	}
+}
+
+func TestMultipleClientsAdvice(t *testing.T) {
+	srv := NewServer()
+	defer srv.Close()
+	first := handshakeAndConnect(t, srv, "browser-1", 5000)
+	second := handshakeAndConnect(t, srv, "browser-1", 5000)
+
+	held := make(chan *message.BayeuxMessage)
+	go func() {
+		held <- srv.HandleMessageContext(browserContext("browser-1"), &message.BayeuxMessage{Channel: "/meta/connect", ClientID: first})
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	resp := srv.HandleMessageContext(browserContext("browser-1"), &message.BayeuxMessage{Channel: "/meta/connect", ClientID: second})
+	if resp.Advice == nil || !resp.Advice.MultipleClients {
+		t.Fatalf("Expected multiple-clients advice, got %+v", resp.Advice)
+	}
+	if resp.Advice.Interval != 2000 {
+		t.Errorf("Expected interval 2000, got %d", resp.Advice.Interval)
+	}
+
+	srv.HandleMessage(&message.BayeuxMessage{Channel: "/meta/disconnect", ClientID: first})
+	select {
+	case <-held:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Held connect was not released by disconnect")
+	}
+	if n := srv.browsers["browser-1"]; n != 0 {
+		t.Errorf("Expected browser slot to be released, got %d", n)
+	}
+}
+
+func TestFanoutKeepsChannelOrder(t *testing.T) {
+	srv, err := New(WithFanout(4, 2))
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer srv.Close()
+	clientID := srv.HandleMessage(&message.BayeuxMessage{Channel: "/meta/handshake"}).ClientID
+	srv.HandleMessage(&message.BayeuxMessage{Channel: "/meta/subscribe", ClientID: clientID, Subscription: "/ordered"})
+	for i := 0; i < 50; i++ {
+		srv.HandleMessage(&message.BayeuxMessage{Channel: "/ordered", ClientID: clientID, Data: message.MustData(i)})
+	}
+	waitForFanout(t, srv)
+	msgs := srv.getSession(clientID).DequeueAll()
+	if len(msgs) != 50 {
+		t.Fatalf("Expected 50 messages, got %d", len(msgs))
+	}
+	for i, msg := range msgs {
+		var n int
+		if err := msg.DecodeData(&n); err != nil || n != i {
+			t.Fatalf("Expected message %d in order, got %s", i, msg.Data)
+		}
+	}
+}
+
+func TestCloseDeliversSynchronously(t *testing.T) {
+	srv := NewServer()
+	defer srv.Close()
+	clientID := srv.HandleMessage(&message.BayeuxMessage{Channel: "/meta/handshake"}).ClientID
+	srv.HandleMessage(&message.BayeuxMessage{Channel: "/meta/subscribe", ClientID: clientID, Subscription: "/foo"})
+	srv.Close()
+	srv.HandleMessage(&message.BayeuxMessage{Channel: "/foo", ClientID: clientID, Data: message.MustData(1)})
+	if n := srv.getSession(clientID).QueueLen(); n != 1 {
+		t.Errorf("Expected message delivered after Close, got %d queued", n)
+	}
+}
+
+func BenchmarkParallelHandshake(b *testing.B) {
+	srv := NewServer()
+	defer srv.Close()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			srv.HandleMessage(&message.BayeuxMessage{Channel: "/meta/handshake"})
+		}
+	})
+}
+
+func BenchmarkParallelSubscribe(b *testing.B) {
+	srv := NewServer()
+	defer srv.Close()
+	var n atomic.Int64
+	b.RunParallel(func(pb *testing.PB) {
+		clientID := srv.HandleMessage(&message.BayeuxMessage{Channel: "/meta/handshake"}).ClientID
+		for pb.Next() {
+			channel := fmt.Sprintf("/bench/%d", n.Add(1)%1024)
+			srv.HandleMessage(&message.BayeuxMessage{Channel: "/meta/subscribe", ClientID: clientID, Subscription: channel})
+		}
+	})
+}
+
+func BenchmarkParallelPublish(b *testing.B) {
+	srv, err := New(WithMaxQueue(16))
+	if err != nil {
+		b.Fatalf("New failed: %v", err)
+	}
+	defer srv.Close()
+	var n atomic.Int64
+	b.RunParallel(func(pb *testing.PB) {
+		clientID := srv.HandleMessage(&message.BayeuxMessage{Channel: "/meta/handshake"}).ClientID
+		channel := fmt.Sprintf("/bench/%d", n.Add(1))
+		srv.HandleMessage(&message.BayeuxMessage{Channel: "/meta/subscribe", ClientID: clientID, Subscription: channel})
+		data := message.MustData("payload")
+		for pb.Next() {
+			srv.HandleMessage(&message.BayeuxMessage{Channel: channel, ClientID: clientID, Data: data})
+		}
+	})
+}
+
+func BenchmarkParallelMixed(b *testing.B) {
+	srv, err := New(WithMaxQueue(16))
+	if err != nil {
+		b.Fatalf("New failed: %v", err)
+	}
+	defer srv.Close()
+	var n atomic.Int64
+	b.RunParallel(func(pb *testing.PB) {
+		data := message.MustData("payload")
+		for pb.Next() {
+			i := n.Add(1)
+			clientID := srv.HandleMessage(&message.BayeuxMessage{Channel: "/meta/handshake"}).ClientID
+			channel := fmt.Sprintf("/bench/%d", i%64)
+			srv.HandleMessage(&message.BayeuxMessage{Channel: "/meta/subscribe", ClientID: clientID, Subscription: channel})
+			srv.HandleMessage(&message.BayeuxMessage{Channel: channel, ClientID: clientID, Data: data})
+			srv.HandleMessage(&message.BayeuxMessage{Channel: "/meta/disconnect", ClientID: clientID})
+		}
+	})
+}
+
+func TestSubscribeRacingDisconnect(t *testing.T) {
+	srv := NewServer()
+	defer srv.Close()
+	for i := 0; i < 200; i++ {
+		clientID := srv.HandleMessage(&message.BayeuxMessage{Channel: "/meta/handshake"}).ClientID
+		sess := srv.getSession(clientID)
+		done := make(chan struct{})
+		go func() {
+			srv.Disconnect(clientID)
+			close(done)
+		}()
+		if sess != nil {
+			srv.handleSubscribe(context.Background(), &message.BayeuxMessage{Channel: "/meta/subscribe", ClientID: clientID, Subscription: "/race"})
+		}
+		<-done
+	}
+	if subs, _ := srv.ChannelSubscribers("/race"); len(subs) != 0 {
+		t.Errorf("Expected removed sessions not to stay subscribed, got %d", len(subs))
+	}
+}
+
+func TestPublishReachesWildcardSubscribers(t *testing.T) {
+	srv := NewServer()
+	defer srv.Close()
+	clientID := srv.HandleMessage(&message.BayeuxMessage{Channel: "/meta/handshake"}).ClientID
+	for _, sub := range []string{"/stocks/*", "/stocks/**", "/stocks/acme"} {
+		srv.HandleMessage(&message.BayeuxMessage{Channel: "/meta/subscribe", ClientID: clientID, Subscription: sub})
+	}
+	srv.HandleMessage(&message.BayeuxMessage{Channel: "/stocks/acme", ClientID: clientID, Data: message.MustData(1)})
+	srv.HandleMessage(&message.BayeuxMessage{Channel: "/stocks/acme/ask", ClientID: clientID, Data: message.MustData(2)})
+	srv.HandleMessage(&message.BayeuxMessage{Channel: "/bonds/acme", ClientID: clientID, Data: message.MustData(3)})
+	waitForFanout(t, srv)
+	if n := srv.getSession(clientID).QueueLen(); n != 2 {
+		t.Errorf("Expected 2 messages delivered once each, got %d", n)
+	}
+}
+
+func TestDeliveryOmitsPublisherFields(t *testing.T) {
+	srv := NewServer()
+	defer srv.Close()
+	publisher := srv.HandleMessage(&message.BayeuxMessage{Channel: "/meta/handshake"}).ClientID
+	subscriber := srv.HandleMessage(&message.BayeuxMessage{Channel: "/meta/handshake"}).ClientID
+	srv.HandleMessage(&message.BayeuxMessage{Channel: "/meta/subscribe", ClientID: subscriber, Subscription: "/x"})
+	srv.HandleMessage(&message.BayeuxMessage{
+		Channel:  "/x",
+		ClientID: publisher,
+		ID:       "7",
+		Data:     message.MustData(1),
+		Ext:      map[string]json.RawMessage{"secret": message.MustData("s")},
+	})
+	waitForFanout(t, srv)
+	msgs := srv.getSession(subscriber).DequeueAll()
+	if len(msgs) != 1 {
+		t.Fatalf("Expected 1 delivered message, got %d", len(msgs))
+	}
+	got, _ := json.Marshal(msgs[0])
+	if string(got) != `{"channel":"/x","data":1,"id":"7"}` {
+		t.Errorf("Expected only channel, data and id to be delivered, got %s", got)
+	}
+}
+
+func TestSubscriptionFilter(t *testing.T) {
+	srv := NewServer()
+	defer srv.Close()
+	clientID := srv.HandleMessage(&message.BayeuxMessage{Channel: "/meta/handshake"}).ClientID
+	resp := srv.HandleMessage(&message.BayeuxMessage{
+		Channel:      "/meta/subscribe",
+		ClientID:     clientID,
+		Subscription: "/prices",
+		Ext:          map[string]json.RawMessage{FilterExt: json.RawMessage(`"symbol in ('AAPL', 'MSFT') && price >= 100"`)},
+	})
+	if resp.Successful == nil || !*resp.Successful {
+		t.Fatalf("Expected filtered subscribe to succeed, got %+v", resp)
+	}
+	for _, data := range []string{
+		`{"symbol":"AAPL","price":150}`,
+		`{"symbol":"GOOG","price":150}`,
+		`{"symbol":"MSFT","price":50}`,
+		`{"symbol":"MSFT","price":100}`,
+	} {
+		srv.HandleMessage(&message.BayeuxMessage{Channel: "/prices", ClientID: clientID, Data: json.RawMessage(data)})
+	}
+	waitForFanout(t, srv)
+	msgs := srv.getSession(clientID).DequeueAll()
+	if len(msgs) != 2 || string(msgs[0].Data) != `{"symbol":"AAPL","price":150}` || string(msgs[1].Data) != `{"symbol":"MSFT","price":100}` {
+		t.Errorf("Expected only matching messages, got %d", len(msgs))
+	}
+
+	for ext, want := range map[string]string{
+		`"price >"`: "400:/prices:Invalid filter: selector: at offset 7: expected value but found end of expression",
+		`42`:        "400:/prices:Invalid filter: filter must be a string",
+	} {
+		resp := srv.HandleMessage(&message.BayeuxMessage{
+			Channel:      "/meta/subscribe",
+			ClientID:     clientID,
+			Subscription: "/prices",
+			Ext:          map[string]json.RawMessage{FilterExt: json.RawMessage(ext)},
+		})
+		if resp.Successful == nil || *resp.Successful || resp.Error != want || resp.Subscription != "/prices" {
+			t.Errorf("%s: expected error %q, got %+v", ext, want, resp)
+		}
+	}
+}
+
+func TestValidationErrorCodes(t *testing.T) {
+	srv := NewServer()
+	defer srv.Close()
+	clientID := srv.HandleMessage(&message.BayeuxMessage{Channel: "/meta/handshake"}).ClientID
+	cases := []struct {
+		msg  *message.BayeuxMessage
+		want string
+	}{
+		{&message.BayeuxMessage{Channel: "/meta/connect"}, "400::Missing clientId"},
+		{&message.BayeuxMessage{Channel: "/meta/connect", ClientID: "gone"}, "402:gone:Unknown client"},
+		{&message.BayeuxMessage{Channel: "/meta/subscribe", ClientID: clientID, Subscription: "foo"}, "405:foo:Invalid channel"},
+		{&message.BayeuxMessage{Channel: "/meta/subscribe", ClientID: clientID, Subscription: "/meta/connect"}, "403:/meta/connect:Meta channels cannot be subscribed to"},
+		{&message.BayeuxMessage{Channel: "/foo/*", ClientID: clientID, Data: message.MustData(1)}, "405:/foo/*:Invalid channel"},
+		{&message.BayeuxMessage{Channel: "/foo", ClientID: clientID}, "400:/foo:Missing data"},
+	}
+	for _, c := range cases {
+		resp := srv.HandleMessage(c.msg)
+		if resp.Error != c.want {
+			t.Errorf("%s: expected error %q, got %q", c.msg.Channel, c.want, resp.Error)
+		}
+	}
+	resp := srv.HandleMessage(&message.BayeuxMessage{Channel: "/meta/connect", ClientID: "gone"})
+	if resp.Advice == nil || resp.Advice.Reconnect != "handshake" {
+		t.Errorf("Expected unknown client to be advised to handshake, got %+v", resp.Advice)
+	}
+	if resp := srv.HandleMessage(&message.BayeuxMessage{ID: "1"}); resp.Channel != UnsuccessfulChannel || resp.Error != "400::Missing channel" {
+		t.Errorf("Expected missing channel to be reported on %s, got %+v", UnsuccessfulChannel, resp)
+	}
+}
+
+// checkSubscriptions verifies that channel subscribers and session
+// subscriptions agree, so no subscription outlives its session.
+func checkSubscriptions(t *testing.T, srv *Server) {
+	t.Helper()
+	for _, ch := range srv.channels.values() {
+		for _, id := range ch.SubscriberIDs() {
+			sess := srv.getSession(id)
+			if sess == nil {
+				t.Fatalf("Channel %s has orphaned subscriber %s", ch.Name, id)
+			}
+			if !sess.IsSubscribed(ch.Name) {
+				t.Fatalf("Channel %s lists %s, which is not subscribed to it", ch.Name, id)
+			}
+		}
+	}
+	for _, sess := range srv.sessions.values() {
+		for _, sub := range sess.SubscriptionList() {
+			ch, ok := srv.channels.get(sub)
+			if !ok || !slices.Contains(ch.SubscriberIDs(), sess.ID) {
+				t.Fatalf("Session %s is subscribed to %s but not listed by the channel", sess.ID, sub)
+			}
+		}
+	}
+}
+
+func FuzzHandleMessageSequence(f *testing.F) {
+	f.Add([]byte{0, 2, 0x12, 4, 1, 5})
+	f.Add([]byte{0, 0, 0x22, 0x32, 3, 0x13, 5, 4})
+	f.Add([]byte{0, 6, 0x42, 0x72, 4, 0x54, 1})
+	channels := []string{"/a", "/a/b", "/a/*", "/a/**", "/**", "/meta/x", "bad", ""}
+
+	f.Fuzz(func(t *testing.T, ops []byte) {
+		srv, err := New(WithAdvice(message.Advice{Reconnect: "retry", Timeout: 0}), WithFanout(1, 64))
+		if err != nil {
+			t.Fatalf("New failed: %v", err)
+		}
+		defer srv.Close()
+		clients := []string{"", "unknown"}
+		for i, op := range ops {
+			clientID := clients[i%len(clients)]
+			ch := channels[int(op>>4)%len(channels)]
+			var msg *message.BayeuxMessage
+			switch op % 7 {
+			case 0:
+				msg = &message.BayeuxMessage{Channel: "/meta/handshake"}
+			case 1:
+				msg = &message.BayeuxMessage{Channel: "/meta/connect", ClientID: clientID}
+			case 2:
+				msg = &message.BayeuxMessage{Channel: "/meta/subscribe", ClientID: clientID, Subscription: ch}
+			case 3:
+				msg = &message.BayeuxMessage{Channel: "/meta/unsubscribe", ClientID: clientID, Subscription: ch}
+			case 4:
+				msg = &message.BayeuxMessage{Channel: ch, ClientID: clientID, Data: message.MustData(i)}
+			case 5:
+				msg = &message.BayeuxMessage{Channel: "/meta/disconnect", ClientID: clientID}
+			case 6:
+				msg = &message.BayeuxMessage{Channel: ch, ClientID: clientID}
+			}
+			resp := srv.HandleMessage(msg)
+			if resp == nil || resp.Channel == "" {
+				t.Fatalf("Op %d (%s): reply without a channel: %+v", i, msg.Channel, resp)
+			}
+			if resp.Channel == "/meta/handshake" && resp.ClientID != "" {
+				clients = append(clients, resp.ClientID)
+			}
+			checkSubscriptions(t, srv)
+		}
+	})
+}
+
+func TestHandleMessagesDeliversAllWithConnectReply(t *testing.T) {
+	srv := NewServer()
+	defer srv.Close()
+	clientID := handshakeAndConnect(t, srv, "", 5000)
+	srv.HandleMessage(&message.BayeuxMessage{Channel: "/meta/subscribe", ClientID: clientID, Subscription: "/feed"})
+	for i := 0; i < 3; i++ {
+		srv.HandleMessage(&message.BayeuxMessage{Channel: "/feed", ClientID: clientID, Data: message.MustData(i)})
+	}
+	waitForFanout(t, srv)
+
+	replies := srv.HandleMessages([]*message.BayeuxMessage{{Channel: "/meta/connect", ClientID: clientID, ID: "c"}})
+	if len(replies) != 4 {
+		t.Fatalf("Expected 3 messages and the connect reply, got %d", len(replies))
+	}
+	for i, msg := range replies[:3] {
+		var n int
+		if err := msg.DecodeData(&n); err != nil || n != i {
+			t.Errorf("Expected message %d, got %s", i, msg.Data)
+		}
+	}
+	if last := replies[3]; last.Channel != "/meta/connect" || last.ID != "c" || last.Successful == nil || !*last.Successful {
+		t.Errorf("Expected successful connect reply last, got %+v", last)
+	}
+}
+
+func TestHandleMessageLeavesUndeliveredQueued(t *testing.T) {
+	srv := NewServer()
+	defer srv.Close()
+	clientID := handshakeAndConnect(t, srv, "", 5000)
+	srv.HandleMessage(&message.BayeuxMessage{Channel: "/meta/subscribe", ClientID: clientID, Subscription: "/feed"})
+	for i := 0; i < 3; i++ {
+		srv.HandleMessage(&message.BayeuxMessage{Channel: "/feed", ClientID: clientID, Data: message.MustData(i)})
+	}
+	waitForFanout(t, srv)
+
+	for i := 0; i < 3; i++ {
+		resp := srv.HandleMessage(&message.BayeuxMessage{Channel: "/meta/connect", ClientID: clientID})
+		var n int
+		if err := resp.DecodeData(&n); err != nil || n != i {
+			t.Fatalf("Expected message %d, got %+v", i, resp)
+		}
+	}
+}
+
+func TestPiggybackOption(t *testing.T) {
+	for _, enabled := range []bool{false, true} {
+		srv, err := New(WithPiggyback(enabled))
+		if err != nil {
+			t.Fatalf("New failed: %v", err)
+		}
+		defer srv.Close()
+		alice := srv.HandleMessage(&message.BayeuxMessage{Channel: "/meta/handshake"}).ClientID
+		bob := srv.HandleMessage(&message.BayeuxMessage{Channel: "/meta/handshake"}).ClientID
+		srv.HandleMessage(&message.BayeuxMessage{Channel: "/meta/subscribe", ClientID: alice, Subscription: "/chat"})
+		srv.HandleMessage(&message.BayeuxMessage{Channel: "/chat", ClientID: bob, Data: message.MustData("hi alice")})
+		waitForFanout(t, srv)
+
+		replies := srv.HandleMessages([]*message.BayeuxMessage{
+			{Channel: "/other", ClientID: alice, Data: message.MustData("hello"), ID: "p"},
+		})
+		want, queued := 1, 1
+		if enabled {
+			want, queued = 2, 0
+		}
+		if len(replies) != want {
+			t.Fatalf("piggyback=%v: expected %d replies, got %d", enabled, want, len(replies))
+		}
+		if replies[0].ID != "p" || replies[0].Successful == nil {
+			t.Errorf("piggyback=%v: expected publish reply first, got %+v", enabled, replies[0])
+		}
+		if enabled && string(replies[1].Data) != `"hi alice"` {
+			t.Errorf("Expected queued message piggybacked, got %+v", replies[1])
+		}
+		if n := srv.getSession(alice).QueueLen(); n != queued {
+			t.Errorf("piggyback=%v: unexpected queue length %d", enabled, n)
+		}
+		srv.Close()
+	}
+}
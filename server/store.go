@@ -0,0 +1,44 @@
+package server
+
+import (
+	"github.com/charlinchui/galliard/internal/channel"
+	"github.com/charlinchui/galliard/message"
+)
+
+// MessageStore persists the retained messages of channels, so that they
+// survive server restarts. Implementations must be safe for concurrent
+// use. Calls for one channel are made in order, from the goroutine
+// delivering its messages, so slow stores delay delivery on that channel.
+type MessageStore interface {
+	// Load returns the retained message of channel, or nil if there is none.
+	Load(channel string) (*message.BayeuxMessage, error)
+	// Save stores msg as the retained message of channel.
+	Save(channel string, msg *message.BayeuxMessage) error
+	// Delete removes the retained message of channel, if any.
+	Delete(channel string) error
+}
+
+// loadRetained restores the retained message of a new channel from the
+// message store.
+func (s *Server) loadRetained(ch *channel.Channel) {
+	if s.opts.store == nil {
+		return
+	}
+	msg, err := s.opts.store.Load(ch.Name)
+	if err != nil {
+		s.opts.logger.Error("loading retained message failed", "channel", ch.Name, "error", err)
+		return
+	}
+	ch.SetRetained(msg)
+}
+
+// saveRetained records msg as the retained message of ch in the message
+// store.
+func (s *Server) saveRetained(ch *channel.Channel, msg *message.BayeuxMessage) {
+	if s.opts.store == nil {
+		return
+	}
+	if err := s.opts.store.Save(ch.Name, msg); err != nil {
+		s.opts.logger.Error("saving retained message failed", "channel", ch.Name, "error", err)
+	}
+}
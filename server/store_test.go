@@ -0,0 +1,135 @@
+package server
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/charlinchui/galliard/message"
+)
+
+type memoryStore struct {
+	mu   sync.Mutex
+	msgs map[string]*message.BayeuxMessage
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{msgs: map[string]*message.BayeuxMessage{}}
+}
+
+func (m *memoryStore) Load(channel string) (*message.BayeuxMessage, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.msgs[channel], nil
+}
+
+func (m *memoryStore) Save(channel string, msg *message.BayeuxMessage) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.msgs[channel] = msg
+	return nil
+}
+
+func (m *memoryStore) Delete(channel string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.msgs, channel)
+	return nil
+}
+
+func TestRetainedMessages(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	store := newMemoryStore()
+	srv, err := New(WithClock(clock), WithRetainedChannels("/status/**"), WithMessageStore(store), WithChannelSweep(time.Minute))
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer srv.Close()
+	publisher := srv.HandleMessage(&message.BayeuxMessage{Channel: "/meta/handshake"}).ClientID
+	for i := 1; i <= 2; i++ {
+		srv.HandleMessage(&message.BayeuxMessage{Channel: "/status/db", ClientID: publisher, Data: message.MustData(i)})
+		srv.HandleMessage(&message.BayeuxMessage{Channel: "/chat", ClientID: publisher, Data: message.MustData(i)})
+	}
+	waitForFanout(t, srv)
+
+	clientID := srv.HandleMessage(&message.BayeuxMessage{Channel: "/meta/handshake"}).ClientID
+	for _, sub := range []string{"/status/db", "/chat", "/status/*"} {
+		srv.HandleMessage(&message.BayeuxMessage{Channel: "/meta/subscribe", ClientID: clientID, Subscription: sub})
+	}
+	msgs := srv.getSession(clientID).DequeueAll()
+	if len(msgs) != 1 || msgs[0].Channel != "/status/db" || string(msgs[0].Data) != "2" {
+		t.Fatalf("Expected the last status to be delivered once on subscribe, got %d messages", len(msgs))
+	}
+	srv.HandleMessage(&message.BayeuxMessage{Channel: "/meta/subscribe", ClientID: clientID, Subscription: "/status/db"})
+	if n := srv.getSession(clientID).QueueLen(); n != 0 {
+		t.Errorf("Expected no redelivery when already subscribed, got %d", n)
+	}
+	if msg := store.msgs["/status/db"]; msg == nil || string(msg.Data) != "2" {
+		t.Errorf("Expected the last status to be stored, got %+v", msg)
+	}
+
+	// A retained message keeps its channel from being swept, and is
+	// restored from the store by a new server.
+	srv.HandleMessage(&message.BayeuxMessage{Channel: "/meta/disconnect", ClientID: clientID})
+	clock.Advance(2 * time.Minute)
+	srv.sweepChannels()
+	if _, ok := srv.getChannel("/status/db"); !ok {
+		t.Errorf("Expected channel with retained message to be kept")
+	}
+	restarted, err := New(WithRetainedChannels("/status/**"), WithMessageStore(store))
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer restarted.Close()
+	ch, err := restarted.Channel("/status/db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg := ch.Retained(); msg == nil || string(msg.Data) != "2" {
+		t.Errorf("Expected retained message to be restored, got %+v", msg)
+	}
+
+	if err := ch.ClearRetained(); err != nil {
+		t.Fatalf("ClearRetained failed: %v", err)
+	}
+	if ch.Retained() != nil || store.msgs["/status/db"] != nil {
+		t.Errorf("Expected retained message to be cleared")
+	}
+}
+
+// blockingStore holds each Save until release is closed.
+type blockingStore struct {
+	*memoryStore
+	release chan struct{}
+}
+
+func (b *blockingStore) Save(channel string, msg *message.BayeuxMessage) error {
+	<-b.release
+	return b.memoryStore.Save(channel, msg)
+}
+
+func TestClearRetainedAfterPublishInFlight(t *testing.T) {
+	store := &blockingStore{memoryStore: newMemoryStore(), release: make(chan struct{})}
+	srv, err := New(WithRetainedChannels("/status"), WithMessageStore(store))
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer srv.Close()
+	ch, err := srv.Channel("/status")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.Publish("/status", message.MustData("up")); err != nil {
+		t.Fatalf("Publish failed: %v", err)
+	}
+	cleared := make(chan error)
+	go func() { cleared <- ch.ClearRetained() }()
+	time.Sleep(20 * time.Millisecond)
+	close(store.release)
+	if err := <-cleared; err != nil {
+		t.Fatalf("ClearRetained failed: %v", err)
+	}
+	if ch.Retained() != nil || store.msgs["/status"] != nil {
+		t.Errorf("Expected the in-flight publish to be cleared too")
+	}
+}
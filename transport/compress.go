@@ -0,0 +1,132 @@
+package transport
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Compression configures negotiated response compression for a transport
+// handler. Responses are compressed with gzip or deflate according to the
+// request's Accept-Encoding header. A nil *Compression disables it.
+// It applies to HTTP responses only: the package has no WebSocket
+// transport, and so no permessage-deflate support.
+type Compression struct {
+	// MinSize is the smallest response body, in bytes, that is compressed.
+	// Smaller responses are sent as-is since compressing them rarely pays off.
+	MinSize int
+
+	// Level is the compression level, as defined by compress/flate.
+	// Zero, or a level outside flate.HuffmanOnly to flate.BestCompression,
+	// selects flate.DefaultCompression.
+	Level int
+
+	gzipPool sync.Pool
+	zlibPool sync.Pool
+}
+
+// DefaultCompression returns the compression settings used by NewHTTPHandler.
+func DefaultCompression() *Compression {
+	return &Compression{MinSize: 1024}
+}
+
+func (c *Compression) level() int {
+	if c.Level == 0 || c.Level < flate.HuffmanOnly || c.Level > flate.BestCompression {
+		return flate.DefaultCompression
+	}
+	return c.Level
+}
+
+// write sends body to w, compressed if the client accepts a supported
+// encoding and body is at least MinSize bytes long. If compression fails,
+// body is sent uncompressed.
+func (c *Compression) write(w http.ResponseWriter, r *http.Request, body []byte) error {
+	encoding := ""
+	if len(body) >= c.MinSize {
+		encoding = negotiateEncoding(r.Header.Get("Accept-Encoding"))
+	}
+	w.Header().Add("Vary", "Accept-Encoding")
+	if encoding == "" {
+		_, err := w.Write(body)
+		return err
+	}
+
+	var buf bytes.Buffer
+	if err := c.compress(&buf, encoding, body); err != nil {
+		_, err := w.Write(body)
+		return err
+	}
+	w.Header().Set("Content-Encoding", encoding)
+	w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+func (c *Compression) compress(dst io.Writer, encoding string, body []byte) error {
+	switch encoding {
+	case "gzip":
+		zw, _ := c.gzipPool.Get().(*gzip.Writer)
+		if zw == nil {
+			var err error
+			if zw, err = gzip.NewWriterLevel(dst, c.level()); err != nil {
+				return err
+			}
+		} else {
+			zw.Reset(dst)
+		}
+		defer c.gzipPool.Put(zw)
+		if _, err := zw.Write(body); err != nil {
+			return err
+		}
+		return zw.Close()
+	default:
+		zw, _ := c.zlibPool.Get().(*zlib.Writer)
+		if zw == nil {
+			var err error
+			if zw, err = zlib.NewWriterLevel(dst, c.level()); err != nil {
+				return err
+			}
+		} else {
+			zw.Reset(dst)
+		}
+		defer c.zlibPool.Put(zw)
+		if _, err := zw.Write(body); err != nil {
+			return err
+		}
+		return zw.Close()
+	}
+}
+
+// negotiateEncoding picks "gzip" or "deflate" from an Accept-Encoding header,
+// honouring quality values, or returns "" if neither is acceptable.
+func negotiateEncoding(header string) string {
+	best, bestQ := "", 0.0
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+		if name == "*" {
+			name = "gzip"
+		}
+		if q <= 0 || (name != "gzip" && name != "deflate") {
+			continue
+		}
+		if q > bestQ || (q == bestQ && name == "gzip") {
+			best, bestQ = name, q
+		}
+	}
+	return best
+}
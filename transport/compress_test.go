@@ -0,0 +1,117 @@
+package transport
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/charlinchui/galliard/message"
+)
+
+func handshakeBatch(n int) string {
+	msgs := make([]string, n)
+	for i := range msgs {
+		msgs[i] = `{"channel":"/meta/handshake"}`
+	}
+	return "[" + strings.Join(msgs, ",") + "]"
+}
+
+func TestCompression_Gzip(t *testing.T) {
+	h := NewHTTPHandler(newTestServer(t))
+	req := httptest.NewRequest(http.MethodPost, "/bayeux", strings.NewReader(handshakeBatch(20)))
+	req.Header.Set("Accept-Encoding", "gzip, deflate")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	if rec.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("expected gzip encoding, got %q", rec.Header().Get("Content-Encoding"))
+	}
+	zr, err := gzip.NewReader(rec.Body)
+	if err != nil {
+		t.Fatalf("gzip reader: %v", err)
+	}
+	var out []message.BayeuxMessage
+	if err := json.NewDecoder(zr).Decode(&out); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if len(out) != 20 {
+		t.Errorf("expected 20 replies, got %d", len(out))
+	}
+}
+
+func TestCompression_InvalidLevel(t *testing.T) {
+	h := NewHTTPHandler(newTestServer(t))
+	h.Compression = &Compression{MinSize: 1, Level: 10}
+	req := httptest.NewRequest(http.MethodPost, "/bayeux", strings.NewReader(handshakeBatch(20)))
+	req.Header.Set("Accept-Encoding", "gzip")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	zr, err := gzip.NewReader(rec.Body)
+	if err != nil {
+		t.Fatalf("gzip reader: %v", err)
+	}
+	var out []message.BayeuxMessage
+	if err := json.NewDecoder(zr).Decode(&out); err != nil || len(out) != 20 {
+		t.Errorf("expected 20 replies compressed at the default level, got %d (%v)", len(out), err)
+	}
+}
+
+func TestCompression_Deflate(t *testing.T) {
+	h := NewHTTPHandler(newTestServer(t))
+	req := httptest.NewRequest(http.MethodPost, "/bayeux", strings.NewReader(handshakeBatch(20)))
+	req.Header.Set("Accept-Encoding", "gzip;q=0.5, deflate")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	if rec.Header().Get("Content-Encoding") != "deflate" {
+		t.Fatalf("expected deflate encoding, got %q", rec.Header().Get("Content-Encoding"))
+	}
+	zr, err := zlib.NewReader(rec.Body)
+	if err != nil {
+		t.Fatalf("zlib reader: %v", err)
+	}
+	body, err := io.ReadAll(zr)
+	if err != nil || !json.Valid(body) {
+		t.Errorf("expected valid JSON after inflating, got %v", err)
+	}
+}
+
+func TestCompression_BelowThreshold(t *testing.T) {
+	h := NewHTTPHandler(newTestServer(t))
+	req := httptest.NewRequest(http.MethodPost, "/bayeux", strings.NewReader(handshakeBatch(1)))
+	req.Header.Set("Accept-Encoding", "gzip")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	if enc := rec.Header().Get("Content-Encoding"); enc != "" {
+		t.Errorf("expected small reply to stay uncompressed, got %q", enc)
+	}
+	if !json.Valid(rec.Body.Bytes()) {
+		t.Errorf("expected plain JSON body, got %q", rec.Body.String())
+	}
+}
+
+func TestNegotiateEncoding(t *testing.T) {
+	cases := map[string]string{
+		"":                        "",
+		"identity":                "",
+		"gzip":                    "gzip",
+		"deflate":                 "deflate",
+		"deflate, gzip":           "gzip",
+		"gzip;q=0.2, deflate;q=1": "deflate",
+		"gzip;q=0":                "",
+		"*":                       "gzip",
+		"br, deflate;q=0.8":       "deflate",
+	}
+	for header, want := range cases {
+		if got := negotiateEncoding(header); got != want {
+			t.Errorf("negotiateEncoding(%q) = %q, want %q", header, got, want)
+		}
+	}
+}
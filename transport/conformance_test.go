@@ -0,0 +1,27 @@
+package transport
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/charlinchui/galliard/conformance"
+	"github.com/charlinchui/galliard/message"
+	"github.com/charlinchui/galliard/server"
+)
+
+func TestHTTPHandlerConformance(t *testing.T) {
+	srv, err := server.New(server.WithAdvice(message.Advice{Reconnect: "retry", Timeout: 200}))
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	t.Cleanup(srv.Close)
+	ts := httptest.NewServer(NewHTTPHandler(srv))
+	defer ts.Close()
+
+	suite := conformance.Suite{
+		NewTransport: func(*testing.T) conformance.Transport {
+			return &conformance.HTTPTransport{URL: ts.URL}
+		},
+	}
+	suite.Run(t)
+}
@@ -0,0 +1,129 @@
+package transport
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CORS configures cross-origin access to a transport handler.
+// A nil *CORS disables cross-origin handling entirely.
+type CORS struct {
+	// AllowedOrigins lists the origins allowed to reach the handler,
+	// e.g. "https://app.example.com". An entry of "*" allows any origin and
+	// a leading wildcard such as "https://*.example.com" allows subdomains.
+	AllowedOrigins []string
+
+	// AllowCredentials lets browsers send cookies and HTTP authentication.
+	// When set, the request origin is echoed back instead of "*".
+	AllowCredentials bool
+
+	// AllowedHeaders lists the request headers allowed in preflighted requests.
+	// If empty, only Content-Type is allowed.
+	AllowedHeaders []string
+
+	// MaxAge is how long browsers may cache a preflight response.
+	// Zero leaves the browser default in place.
+	MaxAge time.Duration
+}
+
+// AllowOrigin reports whether origin matches one of the allowed origins.
+func (c *CORS) AllowOrigin(origin string) bool {
+	if origin == "" {
+		return false
+	}
+	for _, allowed := range c.AllowedOrigins {
+		if matchOrigin(allowed, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckOrigin reports whether a request that upgrades to a WebSocket
+// connection may proceed. Requests without an Origin header (non-browser
+// clients) and same-origin requests are always accepted; cross-origin
+// requests must match AllowedOrigins. Its signature matches the CheckOrigin
+// hook of common WebSocket upgraders. The package has no WebSocket
+// transport of its own, so it is up to applications that add one to call
+// it.
+func (c *CORS) CheckOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" || sameOrigin(origin, r) {
+		return true
+	}
+	return c.AllowOrigin(origin)
+}
+
+// sameOrigin reports whether origin names the host r was sent to.
+func sameOrigin(origin string, r *http.Request) bool {
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, r.Host)
+}
+
+// handle applies CORS headers to w. It returns true if the request has been
+// fully answered, either as a preflight or as a rejected origin.
+// Same-origin requests, which browsers also send an Origin header with,
+// pass through untouched.
+func (c *CORS) handle(w http.ResponseWriter, r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" || sameOrigin(origin, r) {
+		return false
+	}
+	w.Header().Add("Vary", "Origin")
+	preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+	if !c.AllowOrigin(origin) {
+		http.Error(w, "Origin not allowed", http.StatusForbidden)
+		return true
+	}
+
+	if c.allowsAnyOrigin() && !c.AllowCredentials {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+	} else {
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+	}
+	if c.AllowCredentials {
+		w.Header().Set("Access-Control-Allow-Credentials", "true")
+	}
+	if !preflight {
+		return false
+	}
+
+	w.Header().Add("Vary", "Access-Control-Request-Method")
+	w.Header().Add("Vary", "Access-Control-Request-Headers")
+	w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+	headers := c.AllowedHeaders
+	if len(headers) == 0 {
+		headers = []string{"Content-Type"}
+	}
+	w.Header().Set("Access-Control-Allow-Headers", strings.Join(headers, ", "))
+	if c.MaxAge > 0 {
+		w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(c.MaxAge/time.Second)))
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return true
+}
+
+func (c *CORS) allowsAnyOrigin() bool {
+	for _, allowed := range c.AllowedOrigins {
+		if allowed == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+func matchOrigin(pattern, origin string) bool {
+	if pattern == "*" {
+		return true
+	}
+	prefix, suffix, wildcard := strings.Cut(pattern, "*")
+	if !wildcard {
+		return strings.EqualFold(pattern, origin)
+	}
+	return len(origin) > len(prefix)+len(suffix) &&
+		strings.HasPrefix(strings.ToLower(origin), strings.ToLower(prefix)) &&
+		strings.HasSuffix(strings.ToLower(origin), strings.ToLower(suffix))
+}
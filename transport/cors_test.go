@@ -0,0 +1,138 @@
+package transport
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newCORSHandler(t *testing.T, c *CORS) *HTTPHandler {
+	h := NewHTTPHandler(newTestServer(t))
+	h.CORS = c
+	return h
+}
+
+func TestCORS_Preflight(t *testing.T) {
+	h := newCORSHandler(t, &CORS{
+		AllowedOrigins:   []string{"https://app.example.com"},
+		AllowCredentials: true,
+		AllowedHeaders:   []string{"Content-Type", "Authorization"},
+		MaxAge:           10 * time.Minute,
+	})
+	req := httptest.NewRequest(http.MethodOptions, "/bayeux", nil)
+	req.Header.Set("Origin", "https://app.example.com")
+	req.Header.Set("Access-Control-Request-Method", "POST")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("expected 204, got %d", rec.Code)
+	}
+	hdr := rec.Header()
+	if hdr.Get("Access-Control-Allow-Origin") != "https://app.example.com" {
+		t.Errorf("unexpected allow-origin %q", hdr.Get("Access-Control-Allow-Origin"))
+	}
+	if hdr.Get("Access-Control-Allow-Credentials") != "true" {
+		t.Errorf("expected credentials to be allowed")
+	}
+	if hdr.Get("Access-Control-Allow-Headers") != "Content-Type, Authorization" {
+		t.Errorf("unexpected allow-headers %q", hdr.Get("Access-Control-Allow-Headers"))
+	}
+	if hdr.Get("Access-Control-Max-Age") != "600" {
+		t.Errorf("unexpected max-age %q", hdr.Get("Access-Control-Max-Age"))
+	}
+}
+
+func TestCORS_RejectsUnknownOrigin(t *testing.T) {
+	h := newCORSHandler(t, &CORS{AllowedOrigins: []string{"https://app.example.com"}})
+	for _, method := range []string{http.MethodOptions, http.MethodPost} {
+		req := httptest.NewRequest(method, "/bayeux", strings.NewReader(`[]`))
+		req.Header.Set("Origin", "https://evil.example.net")
+		req.Header.Set("Access-Control-Request-Method", "POST")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != http.StatusForbidden {
+			t.Errorf("%s: expected 403, got %d", method, rec.Code)
+		}
+	}
+}
+
+func TestCORS_SameOriginPost(t *testing.T) {
+	h := newCORSHandler(t, &CORS{AllowedOrigins: []string{"https://app.example.com"}})
+	req := httptest.NewRequest(http.MethodPost, "https://bayeux.example.com/bayeux", strings.NewReader(`[{"channel":"/meta/handshake"}]`))
+	req.Header.Set("Origin", "https://bayeux.example.com")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected same-origin request to be allowed, got %d", rec.Code)
+	}
+	if rec.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Errorf("expected no CORS headers for a same-origin request")
+	}
+}
+
+func TestCORS_SimpleRequestWildcard(t *testing.T) {
+	h := newCORSHandler(t, &CORS{AllowedOrigins: []string{"*"}})
+	req := httptest.NewRequest(http.MethodPost, "/bayeux", strings.NewReader(`[{"channel":"/meta/handshake"}]`))
+	req.Header.Set("Origin", "https://anywhere.example.org")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+	if rec.Header().Get("Access-Control-Allow-Origin") != "*" {
+		t.Errorf("expected wildcard allow-origin, got %q", rec.Header().Get("Access-Control-Allow-Origin"))
+	}
+}
+
+func TestCORS_DisabledKeepsOptionsRejected(t *testing.T) {
+	h := NewHTTPHandler(newTestServer(t))
+	req := httptest.NewRequest(http.MethodOptions, "/bayeux", nil)
+	req.Header.Set("Origin", "https://app.example.com")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected 405, got %d", rec.Code)
+	}
+}
+
+func TestCORS_OriginMatching(t *testing.T) {
+	c := &CORS{AllowedOrigins: []string{"https://*.example.com", "http://localhost:3000"}}
+	cases := map[string]bool{
+		"https://app.example.com":   true,
+		"https://a.b.example.com":   true,
+		"https://example.com":       false,
+		"https://app.example.org":   false,
+		"http://localhost:3000":     true,
+		"http://localhost:3001":     false,
+		"":                          false,
+		"https://.example.com.evil": false,
+	}
+	for origin, want := range cases {
+		if got := c.AllowOrigin(origin); got != want {
+			t.Errorf("AllowOrigin(%q) = %v, want %v", origin, got, want)
+		}
+	}
+}
+
+func TestCORS_CheckOrigin(t *testing.T) {
+	c := &CORS{AllowedOrigins: []string{"https://app.example.com"}}
+	req := httptest.NewRequest(http.MethodGet, "http://bayeux.example.com/ws", nil)
+	if !c.CheckOrigin(req) {
+		t.Errorf("expected request without origin to be accepted")
+	}
+	req.Header.Set("Origin", "http://bayeux.example.com")
+	if !c.CheckOrigin(req) {
+		t.Errorf("expected same-origin request to be accepted")
+	}
+	req.Header.Set("Origin", "https://app.example.com")
+	if !c.CheckOrigin(req) {
+		t.Errorf("expected allowed origin to be accepted")
+	}
+	req.Header.Set("Origin", "https://evil.example.net")
+	if c.CheckOrigin(req) {
+		t.Errorf("expected foreign origin to be rejected")
+	}
+}
@@ -1,48 +1,262 @@
+// Package transport serves a server.Server to Bayeux clients over the
+// HTTP long-polling transport, with request limits, CORS and response
+// compression.
+//
+// There is no WebSocket transport yet. Compression therefore only covers
+// HTTP responses; permessage-deflate is not implemented. CORS is enforced
+// on HTTP requests, and applications that upgrade to WebSocket with their
+// own library can check origins against the same settings with
+// CORS.CheckOrigin.
 package transport
 
 import (
 	"encoding/json"
+	"errors"
 	"io"
 	"net/http"
+	"time"
 
+	"github.com/charlinchui/galliard/internal/utils"
 	"github.com/charlinchui/galliard/message"
 	"github.com/charlinchui/galliard/server"
 )
 
+// Limits bounds the resources a single HTTP request may consume.
+// A zero value for any field disables that particular limit.
+type Limits struct {
+	// MaxBodyBytes is the maximum size of a request body.
+	// Larger requests are rejected with HTTP 413.
+	MaxBodyBytes int64
+
+	// MaxMessages is the maximum number of Bayeux messages in a single batch.
+	// Larger batches are rejected with HTTP 413.
+	MaxMessages int
+
+	// MaxMessageBytes is the maximum encoded size of a single Bayeux message.
+	// Oversized messages receive an unsuccessful Bayeux reply.
+	MaxMessageBytes int
+
+	// MaxDepth is the maximum JSON nesting depth of a single Bayeux message,
+	// counting the message object itself as depth 1.
+	// Messages nested deeper receive an unsuccessful Bayeux reply.
+	MaxDepth int
+}
+
+// DefaultLimits returns the limits used by NewHTTPHandler.
+func DefaultLimits() Limits {
+	return Limits{
+		MaxBodyBytes:    1 << 20,
+		MaxMessages:     100,
+		MaxMessageBytes: 64 << 10,
+		MaxDepth:        32,
+	}
+}
+
+// HTTPHandler serves the Bayeux long-polling transport over HTTP POST.
+//
+// HTTPHandler does not configure connection timeouts itself; those belong to
+// the http.Server that hosts it. ReadHeaderTimeout and ReadTimeout should be
+// short to bound slow clients, WriteTimeout must be longer than the advice
+// timeout so held /meta/connect requests can complete, and IdleTimeout bounds
+// keep-alive connections between polls. NewHTTPServer applies suitable values.
 type HTTPHandler struct {
 	Server *server.Server
+	Limits Limits
+	// CORS enables cross-origin requests and preflight handling when non-nil.
+	CORS *CORS
+	// Compression enables gzip and deflate responses when non-nil.
+	Compression *Compression
 }
 
+// NewHTTPHandler creates an HTTPHandler for s using DefaultLimits and DefaultCompression.
 func NewHTTPHandler(s *server.Server) *HTTPHandler {
-	return &HTTPHandler{Server: s}
+	return &HTTPHandler{
+		Server:      s,
+		Limits:      DefaultLimits(),
+		Compression: DefaultCompression(),
+	}
+}
+
+// NewHTTPServer returns an http.Server for handler listening on addr,
+// with read, write and idle timeouts suited to long-polling Bayeux clients.
+func NewHTTPServer(addr string, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: 5 * time.Second,
+		ReadTimeout:       15 * time.Second,
+		WriteTimeout:      60 * time.Second,
+		IdleTimeout:       120 * time.Second,
+	}
 }
 
+// ServeHTTP handles a batch of Bayeux messages posted as a JSON array.
+// A body that is not a JSON array is rejected with HTTP 400, but a message
+// that cannot be decoded, or exceeds the per-message Limits, receives an
+// unsuccessful Bayeux reply while the rest of the batch is processed.
 func (h *HTTPHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h.CORS != nil && h.CORS.handle(w, r) {
+		return
+	}
 	if r.Method != http.MethodPost {
 		http.Error(w, "Only POST allowed", http.StatusMethodNotAllowed)
 		return
 	}
 	defer r.Body.Close()
+	if h.Limits.MaxBodyBytes > 0 {
+		if r.ContentLength > h.Limits.MaxBodyBytes {
+			http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, h.Limits.MaxBodyBytes)
+	}
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+			return
+		}
 		http.Error(w, "Failed to read body", http.StatusBadRequest)
 		return
 	}
 
-	var reqMsgs []message.BayeuxMessage
-	if err := json.Unmarshal(body, &reqMsgs); err != nil {
+	var rawMsgs []json.RawMessage
+	if err := json.Unmarshal(body, &rawMsgs); err != nil {
 		http.Error(w, "Invalid JSON", http.StatusBadRequest)
 		return
 	}
+	if h.Limits.MaxMessages > 0 && len(rawMsgs) > h.Limits.MaxMessages {
+		http.Error(w, "Too many messages", http.StatusRequestEntityTooLarge)
+		return
+	}
 
-	var respMsgs []message.BayeuxMessage
-	for i := range reqMsgs {
-		resp := h.Server.HandleMessage(&reqMsgs[i])
-		if resp != nil {
-			respMsgs = append(respMsgs, *resp)
+	ctx := server.WithRequest(r.Context(), &server.Request{
+		Transport:  "long-polling",
+		RemoteAddr: r.RemoteAddr,
+		Header:     r.Header,
+		TLS:        r.TLS,
+		BrowserID:  h.browserCookie(w, r),
+	})
+	var reqMsgs, respMsgs []*message.BayeuxMessage
+	for _, raw := range rawMsgs {
+		if errMsg := h.checkMessage(raw); errMsg != "" {
+			respMsgs = append(respMsgs, rejectMessage(raw, errMsg))
+			continue
+		}
+		msg := new(message.BayeuxMessage)
+		if err := json.Unmarshal(raw, msg); err != nil {
+			respMsgs = append(respMsgs, rejectMessage(raw, "400::Invalid message"))
+			continue
 		}
+		reqMsgs = append(reqMsgs, msg)
+	}
+	respMsgs = append(respMsgs, h.Server.HandleMessagesContext(ctx, reqMsgs)...)
+	if respMsgs == nil {
+		respMsgs = []*message.BayeuxMessage{}
 	}
 
+	body, err = json.Marshal(respMsgs)
+	if err != nil {
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(respMsgs)
+	if h.Compression != nil {
+		h.Compression.write(w, r, body)
+		return
+	}
+	w.Write(body)
+}
+
+// BrowserCookie is the name of the cookie identifying a browser across the
+// Bayeux clients it runs, so that the server can detect several tabs
+// long-polling from the same browser.
+const BrowserCookie = "BAYEUX_BROWSER"
+
+// browserCookie returns the browser identifier carried by r,
+// issuing a new BrowserCookie on w if the request has none.
+// Cross-origin setups with credentials need SameSite=None, which browsers
+// only accept on secure cookies.
+func (h *HTTPHandler) browserCookie(w http.ResponseWriter, r *http.Request) string {
+	if c, err := r.Cookie(BrowserCookie); err == nil && c.Value != "" {
+		return c.Value
+	}
+	sameSite := http.SameSiteLaxMode
+	if h.CORS != nil && h.CORS.AllowCredentials && r.TLS != nil {
+		sameSite = http.SameSiteNoneMode
+	}
+	id := utils.GenerateID()
+	http.SetCookie(w, &http.Cookie{
+		Name:     BrowserCookie,
+		Value:    id,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: sameSite,
+	})
+	return id
+}
+
+func (h *HTTPHandler) checkMessage(raw json.RawMessage) string {
+	if h.Limits.MaxMessageBytes > 0 && len(raw) > h.Limits.MaxMessageBytes {
+		return "413::Message too large"
+	}
+	if h.Limits.MaxDepth > 0 && jsonDepth(raw) > h.Limits.MaxDepth {
+		return "400::Message nested too deeply"
+	}
+	return ""
+}
+
+// rejectMessage builds an unsuccessful reply for a message that was not
+// handed to the server, echoing its channel and id when they can be read.
+// Replies to messages without a readable channel use server.UnsuccessfulChannel.
+func rejectMessage(raw json.RawMessage, errMsg string) *message.BayeuxMessage {
+	var envelope struct {
+		Channel string `json:"channel"`
+		ID      string `json:"id"`
+	}
+	json.Unmarshal(raw, &envelope)
+	if envelope.Channel == "" {
+		envelope.Channel = server.UnsuccessfulChannel
+	}
+	success := false
+	return &message.BayeuxMessage{
+		Channel:    envelope.Channel,
+		Successful: &success,
+		Error:      errMsg,
+		ID:         envelope.ID,
+	}
+}
+
+// jsonDepth returns the maximum nesting depth of objects and arrays in data.
+// It does not validate the input.
+func jsonDepth(data []byte) int {
+	depth, maxDepth := 0, 0
+	inString, escaped := false, false
+	for _, c := range data {
+		if inString {
+			switch {
+			case escaped:
+				escaped = false
+			case c == '\\':
+				escaped = true
+			case c == '"':
+				inString = false
+			}
+			continue
+		}
+		switch c {
+		case '"':
+			inString = true
+		case '{', '[':
+			depth++
+			if depth > maxDepth {
+				maxDepth = depth
+			}
+		case '}', ']':
+			depth--
+		}
+	}
+	return maxDepth
 }
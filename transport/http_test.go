@@ -2,18 +2,27 @@ package transport
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"github.com/charlinchui/galliard/message"
 	"github.com/charlinchui/galliard/server"
 )
 
-func TestHTTPHandler_HandshakeSubscribePublish(t *testing.T) {
+// newTestServer returns a server that is closed when the test ends.
+func newTestServer(t testing.TB) *server.Server {
 	srv := server.NewServer()
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestHTTPHandler_HandshakeSubscribePublish(t *testing.T) {
+	srv := newTestServer(t)
 	handler := NewHTTPHandler(srv)
 	ts := httptest.NewServer(handler)
 	defer ts.Close()
@@ -35,10 +44,12 @@ func TestHTTPHandler_HandshakeSubscribePublish(t *testing.T) {
 		t.Fatalf("subscribe failed: %+v", subscribeResp)
 	}
 
+	postBayeux(t, ts.URL, []message.BayeuxMessage{{Channel: "/meta/connect", ClientID: clientID}})
+
 	publishReq := []message.BayeuxMessage{{
 		Channel:  "/foo",
 		ClientID: clientID,
-		Data:     map[string]interface{}{"msg": "hello"},
+		Data:     message.MustData(map[string]interface{}{"msg": "hello"}),
 	}}
 	publishResp := postBayeux(t, ts.URL, publishReq)
 	if len(publishResp) != 1 || publishResp[0].Successful == nil || !*publishResp[0].Successful {
@@ -50,11 +61,44 @@ func TestHTTPHandler_HandshakeSubscribePublish(t *testing.T) {
 		ClientID: clientID,
 	}}
 	connectResp := postBayeux(t, ts.URL, connectReq)
-	if len(connectResp) != 1 || connectResp[0].Channel != "/foo" {
+	if len(connectResp) != 2 || connectResp[0].Channel != "/foo" || connectResp[1].Channel != "/meta/connect" {
 		t.Fatalf("connect did not deliver published message: %+v", connectResp)
 	}
-	if connectResp[0].Data["msg"] != "hello" {
-		t.Errorf("expected 'hello', got %v", connectResp[0].Data["msg"])
+	var data map[string]string
+	if err := connectResp[0].DecodeData(&data); err != nil || data["msg"] != "hello" {
+		t.Errorf("expected 'hello', got %s", connectResp[0].Data)
+	}
+}
+
+func TestHTTPHandler_PublishArrayData(t *testing.T) {
+	srv := newTestServer(t)
+	ts := httptest.NewServer(NewHTTPHandler(srv))
+	defer ts.Close()
+
+	clientID := postBayeux(t, ts.URL, []message.BayeuxMessage{{Channel: "/meta/handshake"}})[0].ClientID
+	postBayeux(t, ts.URL, []message.BayeuxMessage{{
+		Channel:      "/meta/subscribe",
+		ClientID:     clientID,
+		Subscription: "/list",
+	}})
+	postBayeux(t, ts.URL, []message.BayeuxMessage{{Channel: "/meta/connect", ClientID: clientID}})
+
+	body := `[{"channel":"/list","clientId":"` + clientID + `","data":[1,"two",{"three":3}]}]`
+	resp, err := http.Post(ts.URL, "application/json", bytes.NewReader([]byte(body)))
+	if err != nil {
+		t.Fatalf("post: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+
+	connectResp := postBayeux(t, ts.URL, []message.BayeuxMessage{{
+		Channel:  "/meta/connect",
+		ClientID: clientID,
+	}})
+	if len(connectResp) != 2 || string(connectResp[0].Data) != `[1,"two",{"three":3}]` {
+		t.Errorf("expected array data to be delivered verbatim, got %+v", connectResp)
 	}
 }
 
@@ -75,3 +119,231 @@ func postBayeux(t *testing.T, url string, msgs []message.BayeuxMessage) []messag
 	}
 	return out
 }
+
+func TestHTTPHandler_BodyTooLarge(t *testing.T) {
+	handler := NewHTTPHandler(newTestServer(t))
+	handler.Limits.MaxBodyBytes = 64
+	ts := httptest.NewServer(handler)
+	defer ts.Close()
+
+	body := `[{"channel":"/meta/handshake","ext":"` + strings.Repeat("x", 128) + `"}]`
+	resp, err := http.Post(ts.URL, "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("post: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected 413, got %d", resp.StatusCode)
+	}
+}
+
+func TestHTTPHandler_TooManyMessages(t *testing.T) {
+	handler := NewHTTPHandler(newTestServer(t))
+	handler.Limits.MaxMessages = 2
+	ts := httptest.NewServer(handler)
+	defer ts.Close()
+
+	body := `[{"channel":"/meta/handshake"},{"channel":"/meta/handshake"},{"channel":"/meta/handshake"}]`
+	resp, err := http.Post(ts.URL, "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("post: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected 413, got %d", resp.StatusCode)
+	}
+}
+
+func TestHTTPHandler_PerMessageLimits(t *testing.T) {
+	handler := NewHTTPHandler(newTestServer(t))
+	handler.Limits.MaxMessageBytes = 200
+	handler.Limits.MaxDepth = 4
+	ts := httptest.NewServer(handler)
+	defer ts.Close()
+
+	big := `{"channel":"/foo","id":"big","data":"` + strings.Repeat("x", 256) + `"}`
+	deep := `{"channel":"/foo","id":"deep","data":[[[[1]]]]}`
+	ok := `{"channel":"/meta/handshake","id":"ok"}`
+	resp, err := http.Post(ts.URL, "application/json", strings.NewReader("["+big+","+deep+","+ok+"]"))
+	if err != nil {
+		t.Fatalf("post: %v", err)
+	}
+	defer resp.Body.Close()
+	var out []message.BayeuxMessage
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if len(out) != 3 {
+		t.Fatalf("expected 3 replies, got %+v", out)
+	}
+	for _, r := range out[:2] {
+		if r.Channel != "/foo" || r.Successful == nil || *r.Successful || r.Error == "" {
+			t.Errorf("expected rejection for %q, got %+v", r.ID, r)
+		}
+	}
+	if out[0].ID != "big" || out[1].ID != "deep" {
+		t.Errorf("expected rejections to echo ids, got %q and %q", out[0].ID, out[1].ID)
+	}
+	if out[2].Successful == nil || !*out[2].Successful {
+		t.Errorf("expected handshake to succeed, got %+v", out[2])
+	}
+}
+
+func TestHTTPHandler_InvalidMessageInBatch(t *testing.T) {
+	ts := httptest.NewServer(NewHTTPHandler(newTestServer(t)))
+	defer ts.Close()
+
+	body := `[{"channel":1,"id":"bad"},{"channel":"/meta/handshake","id":"ok"}]`
+	resp, err := http.Post(ts.URL, "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("post: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", resp.StatusCode)
+	}
+	var out []message.BayeuxMessage
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if len(out) != 2 {
+		t.Fatalf("expected 2 replies, got %+v", out)
+	}
+	if out[0].ID != "bad" || out[0].Channel != server.UnsuccessfulChannel || out[0].Successful == nil || *out[0].Successful || out[0].Error != "400::Invalid message" {
+		t.Errorf("expected rejection of the invalid message, got %+v", out[0])
+	}
+	if out[1].ID != "ok" || out[1].Successful == nil || !*out[1].Successful {
+		t.Errorf("expected handshake to succeed, got %+v", out[1])
+	}
+}
+
+func TestJSONDepth(t *testing.T) {
+	cases := map[string]int{
+		`1`:                         0,
+		`{}`:                        1,
+		`{"a":[1,{"b":2}]}`:         3,
+		`{"a":"[[[[{{{{"}`:          1,
+		`{"a":"\"[[","b":[[true]]}`: 3,
+	}
+	for in, want := range cases {
+		if got := jsonDepth([]byte(in)); got != want {
+			t.Errorf("jsonDepth(%s) = %d, want %d", in, got, want)
+		}
+	}
+}
+
+func TestHTTPHandler_BrowserCookie(t *testing.T) {
+	h := NewHTTPHandler(newTestServer(t))
+	req := httptest.NewRequest(http.MethodPost, "/bayeux", strings.NewReader(`[{"channel":"/meta/handshake"}]`))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	cookies := rec.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != BrowserCookie || cookies[0].Value == "" {
+		t.Fatalf("expected a %s cookie, got %+v", BrowserCookie, cookies)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/bayeux", strings.NewReader(`[{"channel":"/meta/handshake"}]`))
+	req.AddCookie(cookies[0])
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if len(rec.Result().Cookies()) != 0 {
+		t.Errorf("expected existing cookie to be reused")
+	}
+}
+
+type transportPolicy struct{}
+
+func (transportPolicy) CanHandshake(ctx context.Context, _ *message.BayeuxMessage) bool {
+	req := server.RequestFromContext(ctx)
+	return req != nil && req.Transport == "long-polling" && req.RemoteAddr != "" &&
+		req.Header.Get("X-Client") == "test" && req.BrowserID != ""
+}
+
+func (transportPolicy) CanSubscribe(context.Context, string, string, *message.BayeuxMessage) bool {
+	return true
+}
+
+func (transportPolicy) CanPublish(context.Context, string, string, *message.BayeuxMessage) bool {
+	return true
+}
+
+func TestHTTPHandler_RequestMetadata(t *testing.T) {
+	srv, err := server.New(server.WithPolicy(transportPolicy{}))
+	if err != nil {
+		t.Fatalf("server.New: %v", err)
+	}
+	t.Cleanup(srv.Close)
+	h := NewHTTPHandler(srv)
+	for header, want := range map[string]bool{"test": true, "other": false} {
+		req := httptest.NewRequest(http.MethodPost, "/bayeux", strings.NewReader(`[{"channel":"/meta/handshake"}]`))
+		req.Header.Set("X-Client", header)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		var out []message.BayeuxMessage
+		if err := json.Unmarshal(rec.Body.Bytes(), &out); err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		if len(out) != 1 || out[0].Successful == nil || *out[0].Successful != want {
+			t.Errorf("X-Client %q: expected successful=%v, got %+v", header, want, out)
+		}
+	}
+}
+
+func FuzzHTTPHandler(f *testing.F) {
+	f.Add([]byte(`[{"channel":"/meta/handshake"}]`))
+	f.Add([]byte(`[{"channel":"/meta/connect","clientId":"x"},{"channel":"/foo","data":1}]`))
+	f.Add([]byte(`[{"channel":"/meta/subscribe","subscription":"/a/**"},null,{}]`))
+	f.Add([]byte(`[{"data":[[[[[[1]]]]]]}]`))
+	f.Add([]byte(`{"channel":"/meta/handshake"}`))
+	f.Add([]byte(`[`))
+
+	srv, err := server.New(server.WithAdvice(message.Advice{Reconnect: "retry", Timeout: 0}))
+	if err != nil {
+		f.Fatalf("New failed: %v", err)
+	}
+	f.Cleanup(srv.Close)
+	handler := NewHTTPHandler(srv)
+	handler.Limits.MaxDepth = 4
+
+	f.Fuzz(func(t *testing.T, body []byte) {
+		req := httptest.NewRequest(http.MethodPost, "/bayeux", bytes.NewReader(body))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			return
+		}
+		var replies []*message.BayeuxMessage
+		if err := json.Unmarshal(rec.Body.Bytes(), &replies); err != nil {
+			t.Fatalf("Response is not a JSON array of messages: %v\n%s", err, rec.Body.Bytes())
+		}
+		for _, r := range replies {
+			if r.Channel == "" {
+				t.Errorf("Reply without a channel: %s", rec.Body.Bytes())
+			}
+		}
+	})
+}
+
+func TestHTTPHandler_BatchProcessesConnectLast(t *testing.T) {
+	srv := newTestServer(t)
+	ts := httptest.NewServer(NewHTTPHandler(srv))
+	defer ts.Close()
+
+	clientID := postBayeux(t, ts.URL, []message.BayeuxMessage{{Channel: "/meta/handshake"}})[0].ClientID
+	postBayeux(t, ts.URL, []message.BayeuxMessage{{Channel: "/meta/connect", ClientID: clientID}})
+
+	resp := postBayeux(t, ts.URL, []message.BayeuxMessage{
+		{Channel: "/meta/connect", ClientID: clientID, ID: "1"},
+		{Channel: "/meta/subscribe", ClientID: clientID, Subscription: "/echo", ID: "2"},
+		{Channel: "/echo", ClientID: clientID, Data: message.MustData("ping"), ID: "3"},
+	})
+	var channels []string
+	for _, m := range resp {
+		channels = append(channels, m.Channel+"#"+m.ID)
+	}
+	want := []string{"/meta/subscribe#2", "/echo#3", "/echo#3", "/meta/connect#1"}
+	if strings.Join(channels, ",") != strings.Join(want, ",") {
+		t.Errorf("Expected replies %v, got %v", want, channels)
+	}
+}